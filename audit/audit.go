@@ -0,0 +1,257 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	EventLogin       = "login"
+	EventLoginFailed = "login_failed"
+	EventCall        = "call"
+	EventDenied      = "denied"
+)
+
+var (
+	ErrTampered = errors.New("audit log is tampered")
+)
+
+// Record is a single line of the audit log. Every record keeps hash of
+// the previous one, so changing or removing a record breaks the chain.
+type Record struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Username string    `json:"username"`
+	TenantID string    `json:"tenant_id"`
+	Method   string    `json:"method"`
+	Resource string    `json:"resource"`
+	Code     string    `json:"code"`
+	Message  string    `json:"message"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+type Filter struct {
+	Username string
+	TenantID string
+	Method   string
+	From     time.Time
+	To       time.Time
+}
+
+// Logger appends records to the local file. It is safe for concurrent use.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	seq      uint64
+	lastHash string
+	// size is the size of the log up to the last written record
+	size int64
+	// dirty is set when a failed write may have left a partial record
+	dirty bool
+}
+
+// NewLogger opens audit log at path. Existing log is verified
+// and new records are chained to its last record. Incomplete last
+// record left by a crash is dropped.
+func NewLogger(path string) (*Logger, error) {
+	records, size, err := readRecords(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	logger := &Logger{path: path, size: size}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		logger.seq = last.Seq
+		logger.lastHash = last.Hash
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	err = file.Truncate(size)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot truncate audit log: %w", err)
+	}
+	logger.file = file
+	return logger, nil
+}
+
+func (l *Logger) Log(record Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the chain is advanced only when the record is on disk
+	record.Seq = l.seq + 1
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+	record.PrevHash = l.lastHash
+	hash, err := hashRecord(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+	if l.dirty {
+		err = l.file.Truncate(l.size)
+		if err != nil {
+			return fmt.Errorf("cannot remove partial audit record: %w", err)
+		}
+		l.dirty = false
+	}
+	line = append(line, '\n')
+	_, err = l.file.Write(line)
+	if err != nil {
+		l.dirty = true
+		return fmt.Errorf("cannot write audit record: %w", err)
+	}
+	err = l.file.Sync()
+	if err != nil {
+		l.dirty = true
+		return fmt.Errorf("cannot sync audit log: %w", err)
+	}
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	l.size += int64(len(line))
+	return nil
+}
+
+// Query verifies the whole log and returns records matching the filter.
+// The log is read without the lock up to the last record written when the
+// query started, so records are logged meanwhile. The chain read must end
+// with that record, so records removed from the end are detected too.
+func (l *Logger) Query(filter Filter) ([]*Record, error) {
+	l.mu.Lock()
+	size, seq, lastHash := l.size, l.seq, l.lastHash
+	l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer file.Close()
+	records, _, err := decodeRecords(io.LimitReader(file, size))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(records)) != seq || (len(records) > 0 && records[len(records)-1].Hash != lastHash) {
+		return nil, fmt.Errorf("%w: log does not end with the last written record %d", ErrTampered, seq)
+	}
+	res := make([]*Record, 0)
+	for _, record := range records {
+		if filter.matches(record) {
+			res = append(res, record)
+		}
+	}
+	return res, nil
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// Verify checks hash chain of the audit log at path.
+func Verify(path string) error {
+	_, _, err := readRecords(path)
+	return err
+}
+
+func (f Filter) matches(record *Record) bool {
+	if len(f.Username) > 0 && record.Username != f.Username {
+		return false
+	}
+	if len(f.TenantID) > 0 && record.TenantID != f.TenantID {
+		return false
+	}
+	if len(f.Method) > 0 && record.Method != f.Method {
+		return false
+	}
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// readRecords reads records and checks that every record is chained to
+// the previous one. It also returns the size of the complete records,
+// the last line without the line break is an incomplete record.
+func readRecords(path string) ([]*Record, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer file.Close()
+
+	return decodeRecords(file)
+}
+
+func decodeRecords(data io.Reader) ([]*Record, int64, error) {
+	var records []*Record
+	var prevHash string
+	var seq uint64
+	var size int64
+	reader := bufio.NewReader(data)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot read audit log: %w", err)
+		}
+		size += int64(len(line))
+		record := &Record{}
+		err = json.Unmarshal(line, record)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: cannot unmarshal record after seq %d: %v", ErrTampered, seq, err)
+		}
+		seq++
+		if record.Seq != seq || record.PrevHash != prevHash {
+			return nil, 0, fmt.Errorf("%w: chain is broken at seq %d", ErrTampered, record.Seq)
+		}
+		hash, err := hashRecord(*record)
+		if err != nil {
+			return nil, 0, err
+		}
+		if hash != record.Hash {
+			return nil, 0, fmt.Errorf("%w: hash mismatch at seq %d", ErrTampered, record.Seq)
+		}
+		prevHash = record.Hash
+		records = append(records, record)
+	}
+	return records, size, nil
+}
+
+func hashRecord(record Record) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,120 @@
+package audit_test
+
+import (
+	"bytes"
+	"main/audit"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerQuery(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(path)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "admin", Method: "/pc.AuthService/Login"}))
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLoginFailed, Username: "user1", Method: "/pc.AuthService/Login"}))
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventCall, Username: "admin", Method: "/pc.LaptopService/CreateLaptop"}))
+	require.NoError(t, logger.Close())
+
+	// Records written after reopening continue the chain.
+	logger, err = audit.NewLogger(path)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventCall, Username: "user1", Method: "/pc.LaptopService/RateLaptop"}))
+
+	records, err := logger.Query(audit.Filter{Username: "admin"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(1), records[0].Seq)
+	require.Equal(t, uint64(3), records[1].Seq)
+
+	records, err = logger.Query(audit.Filter{Method: "/pc.AuthService/Login", From: start, To: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	records, err = logger.Query(audit.Filter{From: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(path)
+	require.NoError(t, err)
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "admin"}))
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "user1"}))
+	require.NoError(t, logger.Close())
+	require.NoError(t, audit.Verify(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	tampered := bytes.Replace(data, []byte(`"username":"user1"`), []byte(`"username":"admin"`), 1)
+	require.NoError(t, os.WriteFile(path, tampered, 0600))
+	require.ErrorIs(t, audit.Verify(path), audit.ErrTampered)
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	require.NoError(t, os.WriteFile(path, lines[1], 0600))
+	require.ErrorIs(t, audit.Verify(path), audit.ErrTampered)
+
+	_, err = audit.NewLogger(path)
+	require.ErrorIs(t, err, audit.ErrTampered)
+}
+
+func TestLoggerQueryDetectsRemovedRecords(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(path)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "admin"}))
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "user1"}))
+
+	// the rest of the chain is valid without the last record
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	require.NoError(t, os.WriteFile(path, lines[0], 0600))
+	require.NoError(t, audit.Verify(path))
+	_, err = logger.Query(audit.Filter{})
+	require.ErrorIs(t, err, audit.ErrTampered)
+}
+
+func TestLoggerDropsIncompleteRecord(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(path)
+	require.NoError(t, err)
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "admin"}))
+	require.NoError(t, logger.Close())
+
+	// a crash in the middle of a write leaves the last line incomplete
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":2,"event":"log`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	logger, err = audit.NewLogger(path)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.Log(audit.Record{Event: audit.EventLogin, Username: "user1"}))
+	require.NoError(t, audit.Verify(path))
+
+	records, err := logger.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "user1", records[1].Username)
+	require.Equal(t, uint64(2), records[1].Seq)
+}
@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerFailedWriteKeepsChain(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewLogger(path)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.Log(Record{Event: EventLogin, Username: "admin"}))
+
+	// writes to the read-only file fail
+	file := logger.file
+	readOnly, err := os.Open(path)
+	require.NoError(t, err)
+	defer readOnly.Close()
+	logger.file = readOnly
+	require.Error(t, logger.Log(Record{Event: EventLogin, Username: "lost"}))
+	logger.file = file
+
+	require.NoError(t, logger.Log(Record{Event: EventLogin, Username: "user1"}))
+	records, err := logger.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(2), records[1].Seq)
+}
@@ -0,0 +1,23 @@
+// Package blob stores binary objects, e.g. image files, by keys.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+)
+
+// Store keeps objects by keys. Putting an object with an existing
+// key replaces it, deleting a missing object is not an error.
+type Store interface {
+	// Put reads size bytes of the object from data.
+	Put(ctx context.Context, key string, data io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Ping checks that the store is available.
+	Ping(ctx context.Context) error
+}
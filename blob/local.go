@@ -0,0 +1,101 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps objects as files in the folder.
+type LocalStore struct {
+	folder string
+}
+
+func NewLocalStore(folder string) *LocalStore {
+	return &LocalStore{folder: folder}
+}
+
+// Put writes data to the temporary file first,
+// so readers never see a partially written object.
+func (s *LocalStore) Put(ctx context.Context, key string, data io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.folder, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create folder: %w", err)
+	}
+	file, err := os.CreateTemp(s.folder, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, data)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write file: %w", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close file: %w", err)
+	}
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return fmt.Errorf("cannot rename file: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+	return file, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove file: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that files can be created in the folder.
+func (s *LocalStore) Ping(ctx context.Context) error {
+	err := os.MkdirAll(s.folder, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create folder: %w", err)
+	}
+	file, err := os.CreateTemp(s.folder, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if len(key) == 0 || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.folder, key), nil
+}
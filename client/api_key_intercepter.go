@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// APIKeyInterceptor attaches API key to the requests
+// instead of JWT. It is used by machine clients which
+// should not store user password.
+type APIKeyInterceptor struct {
+	apiKey      string
+	authMethods map[string]bool
+}
+
+func NewAPIKeyIntercepter(apiKey string, authMethods map[string]bool) *APIKeyInterceptor {
+	return &APIKeyInterceptor{
+		apiKey:      apiKey,
+		authMethods: authMethods,
+	}
+}
+
+func (ai *APIKeyInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		log.Printf("--> api key unary intercepter: %v\n", method)
+		if ai.authMethods[method] {
+			return invoker(ai.attachKey(ctx), method, req, reply, cc, opts...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (ai *APIKeyInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		log.Printf("--> api key stream interceptor: %v", method)
+		if ai.authMethods[method] {
+			return streamer(ai.attachKey(ctx), desc, cc, method, opts...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (ai *APIKeyInterceptor) attachKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-api-key", ai.apiKey)
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -16,6 +18,12 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const (
+	uploadChunkSize   = 64 << 10
+	maxUploadAttempts = 5
+	uploadRetryDelay  = 200 * time.Millisecond
+)
+
 type LaptopClient struct {
 	service pb.LaptopServiceClient
 }
@@ -44,6 +52,21 @@ func (client *LaptopClient) CreateLaptop(parCtx context.Context, laptop *pb.Lapt
 	log.Printf("laptop saved with id: %s\n", response.Id)
 }
 
+func (client *LaptopClient) UpdateLaptop(parCtx context.Context, laptop *pb.Laptop) error {
+	req := &pb.UpdateLaptopRequest{
+		Laptop: laptop,
+	}
+	ctx, cancel := context.WithTimeout(parCtx, time.Second*5)
+	defer cancel()
+	_, err := client.service.UpdateLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("cannot update laptop: %w", err)
+	}
+
+	log.Printf("laptop updated with id: %s\n", laptop.GetId())
+	return nil
+}
+
 func (client *LaptopClient) SearchLaptop(parCtx context.Context, filter *pb.Filter) {
 	log.Printf("seacrh filter: %v\n", filter)
 	ctx, cancel := context.WithTimeout(parCtx, 5*time.Second)
@@ -72,6 +95,7 @@ func (client *LaptopClient) SearchLaptop(parCtx context.Context, filter *pb.Filt
 		log.Printf("\t+ cpu cores: %v\n", laptop.GetCpu().GetCores())
 		log.Printf("\t+ ram: %v (%v)\n", laptop.GetRAM().GetValue(), laptop.GetRAM().GetUnit())
 		log.Printf("\t+ price: %v USD\n", laptop.GetPriceUsd())
+		log.Printf("\t+ rating: %.2f (%v scores)\n", response.GetRating().GetBayesianAverage(), response.GetRating().GetRatedCount())
 	}
 }
 
@@ -96,6 +120,11 @@ func (client *LaptopClient) RateLaptop(ctx context.Context, laptopIds []string,
 			}
 			if err != nil {
 				waitResponse <- fmt.Errorf("cannot receive stream response: %w", err)
+				return
+			}
+			if resp.GetError() != nil {
+				log.Printf("rate of laptop %v is rejected: %v\n", resp.GetLaptopId(), resp.GetError().GetMessage())
+				continue
 			}
 
 			log.Printf("recieved data: %v\n", resp)
@@ -122,55 +151,179 @@ func (client *LaptopClient) RateLaptop(ctx context.Context, laptopIds []string,
 	return err
 }
 
-func (client *LaptopClient) UploadImage(patCtx context.Context, laptopId string, imagePath string) {
+// UploadImage uploads the image in the upload session. If the stream
+// breaks, the upload is resumed from the offset committed by the server.
+func (client *LaptopClient) UploadImage(parCtx context.Context, laptopId string, imagePath string) (string, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
-		log.Fatalf("cannot open file: %v", err)
+		return "", fmt.Errorf("cannot open file: %w", err)
 	}
 	defer file.Close()
-	ctx, cancel := context.WithTimeout(patCtx, time.Second*5)
-	defer cancel()
-
-	stream, err := client.service.UploadImage(ctx)
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
 	if err != nil {
-		log.Fatalf("cannot upload image: %v", err)
+		return "", fmt.Errorf("cannot compute checksum: %w", err)
 	}
-	req := &pb.UploadImageRequest{
-		Data: &pb.UploadImageRequest_Info{
-			Info: &pb.ImageInfo{
-				LaptopId:  laptopId,
-				ImageType: filepath.Ext(imagePath),
-			},
+
+	ctx, cancel := context.WithTimeout(parCtx, time.Second*5)
+	initResp, err := client.service.InitiateUpload(ctx, &pb.InitiateUploadRequest{
+		Info: &pb.ImageInfo{
+			LaptopId:  laptopId,
+			ImageType: filepath.Ext(imagePath),
+			Checksum:  hex.EncodeToString(hash.Sum(nil)),
 		},
+		ByteSize: uint64(size),
+	})
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("cannot initiate upload: %w", err)
 	}
+	uploadId := initResp.GetUploadId()
+
+	var offset uint64
+	for attempt := 1; ; attempt++ {
+		resp, err := client.uploadFrom(parCtx, file, uploadId, offset)
+		if err == nil {
+			log.Printf("image uploaded with id: %s, size: %d, digest: %s", resp.GetId(), resp.GetByteSize(), resp.GetDigest())
+			return resp.GetId(), nil
+		}
+		if attempt == maxUploadAttempts || parCtx.Err() != nil || !isRetryableUploadError(err) {
+			return "", fmt.Errorf("cannot upload image: %w", err)
+		}
+		log.Printf("upload %s is interrupted at attempt %d: %v", uploadId, attempt, err)
+		time.Sleep(time.Duration(attempt) * uploadRetryDelay)
 
-	err = stream.Send(req)
+		committed, err := client.queryUpload(parCtx, uploadId)
+		if err != nil && !isRetryableUploadError(err) {
+			return "", fmt.Errorf("cannot query upload: %w", err)
+		}
+		if err == nil {
+			offset = committed
+		}
+		log.Printf("resume upload %s from offset %d", uploadId, offset)
+	}
+}
+
+func (client *LaptopClient) uploadFrom(
+	parCtx context.Context,
+	file *os.File,
+	uploadId string,
+	offset uint64,
+) (*pb.UploadImageResponse, error) {
+	_, err := file.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seek file: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(parCtx, time.Second*5)
+	defer cancel()
+
+	stream, err := client.service.UploadImage(ctx)
 	if err != nil {
-		log.Fatalf("cannot send request: %v", err)
+		return nil, err
 	}
 	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1024)
+	buffer := make([]byte, uploadChunkSize)
 	for {
 		n, err := reader.Read(buffer)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatalf("cannot read chunk to buffer: %v", err)
+			return nil, fmt.Errorf("cannot read chunk to buffer: %w", err)
 		}
 		req := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: &pb.UploadChunk{
+					UploadId: uploadId,
+					Offset:   offset,
+					Data:     buffer[:n],
+				},
 			},
 		}
 		err = stream.Send(req)
 		if err != nil {
-			log.Fatalf("cannot send chunk to server: %v", err)
+			// the real error is returned by the server on receive
+			_, err = stream.CloseAndRecv()
+			return nil, err
 		}
+		offset += uint64(n)
+	}
+	return stream.CloseAndRecv()
+}
+
+func (client *LaptopClient) queryUpload(parCtx context.Context, uploadId string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(parCtx, time.Second*5)
+	defer cancel()
+
+	resp, err := client.service.QueryUpload(ctx, &pb.QueryUploadRequest{UploadId: uploadId})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetCommittedOffset(), nil
+}
+
+func isRetryableUploadError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Internal, codes.Unknown, codes.OutOfRange, codes.Canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// DownloadImage saves the image or its thumbnail of the given size
+// to the folder and returns path of the saved file.
+func (client *LaptopClient) DownloadImage(parCtx context.Context, imageId string, size uint32, folder string) (string, error) {
+	ctx, cancel := context.WithTimeout(parCtx, time.Second*5)
+	defer cancel()
+
+	stream, err := client.service.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageId, Size: size})
+	if err != nil {
+		return "", fmt.Errorf("cannot download image: %w", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return "", fmt.Errorf("cannot receive image info: %w", err)
+	}
+	info := resp.GetInfo()
+	if info == nil {
+		return "", fmt.Errorf("image info is not received")
 	}
-	resp, err := stream.CloseAndRecv()
+
+	imageName := info.GetId()
+	if size > 0 {
+		imageName = fmt.Sprintf("%s_%d", imageName, size)
+	}
+	imagePath := filepath.Join(folder, imageName+info.GetImageType())
+	file, err := os.Create(imagePath)
 	if err != nil {
-		log.Fatalf("cannot receive response: %v", err)
+		return "", fmt.Errorf("cannot create image file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	writer := io.MultiWriter(file, hash)
+	var byteSize uint64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot receive chunk: %w", err)
+		}
+		n, err := writer.Write(resp.GetChunkData())
+		if err != nil {
+			return "", fmt.Errorf("cannot write chunk to file: %w", err)
+		}
+		byteSize += uint64(n)
+	}
+	if byteSize != info.GetByteSize() {
+		return "", fmt.Errorf("image is incomplete: received %d of %d bytes", byteSize, info.GetByteSize())
+	}
+	if size == 0 && hex.EncodeToString(hash.Sum(nil)) != info.GetDigest() {
+		return "", fmt.Errorf("image checksum mismatch")
 	}
-	log.Printf("image uploaded with id: %s, size: %d", resp.GetId(), resp.GetByteSize())
+	log.Printf("image downloaded to %s, size: %d", imagePath, byteSize)
+	return imagePath, nil
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"main/certgen"
+	"strings"
+	"time"
+)
+
+func main() {
+	const op = "cmd.certgen.main"
+
+	outDir := flag.String("out", "cert", "directory for generated files")
+	hosts := flag.String("hosts", "localhost,0.0.0.0,127.0.0.1", "comma separated server hosts")
+	clientName := flag.String("client", "admin", "client certificate common name")
+	ttl := flag.Duration("ttl", 365*24*time.Hour, "certificates lifetime")
+	flag.Parse()
+
+	ca, err := certgen.NewCA("PC book CA", *ttl)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+	err = certgen.WriteFiles(*outDir, "ca", ca.CertPEM, ca.KeyPEM)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+
+	server, err := ca.IssueServerCert(strings.Split(*hosts, ","), *ttl)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+	err = certgen.WriteFiles(*outDir, "server", server.CertPEM, server.KeyPEM)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+
+	client, err := ca.IssueClientCert(*clientName, nil, *ttl)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+	err = certgen.WriteFiles(*outDir, "client", client.CertPEM, client.KeyPEM)
+	if err != nil {
+		log.Fatalf("%v: %v", op, err)
+	}
+	log.Printf("%v: certificates written to %v\n", op, *outDir)
+}
@@ -30,31 +30,44 @@ func main() {
 	parentCtx := context.Background()
 	serverAddr := flag.String("addr", "", "the server address")
 	enableTLS := flag.Bool("tls", false, "enable")
+	enableMTLS := flag.Bool("mtls", false, "authenticate with client certificate, implies TLS")
+	apiKey := flag.String("api-key", "", "API key to use instead of login and password")
 	flag.Parse()
-	log.Printf("dial server %s, TLS: %t", *serverAddr, *enableTLS)
+	if *enableMTLS {
+		*enableTLS = true
+	}
+	log.Printf("dial server %s, TLS: %t, mTLS: %t", *serverAddr, *enableTLS, *enableMTLS)
 	transportOpts := grpc.WithTransportCredentials(insecure.NewCredentials())
 	if *enableTLS {
-		tlsCreds, err := loadTLSCreds()
+		tlsCreds, err := loadTLSCreds(*enableMTLS)
 		if err != nil {
 			log.Fatal(err)
 		}
 		transportOpts = grpc.WithTransportCredentials(tlsCreds)
 	}
-	authConn, err := grpc.NewClient(*serverAddr, transportOpts)
-	if err != nil {
-		log.Fatalf("cannot connect to server with address: %s. Error: (%v)", *serverAddr, err)
-	}
-	authClient := client.NewAuthClient(authConn, username, password)
-	interceptor, err := client.NewAuthIntercepter(parentCtx, authClient, authMethods(), refreshDuration)
-	if err != nil {
-		log.Fatal(err)
+	dialOpts := []grpc.DialOption{transportOpts}
+	if len(*apiKey) > 0 {
+		interceptor := client.NewAPIKeyIntercepter(*apiKey, authMethods())
+		dialOpts = append(dialOpts,
+			grpc.WithUnaryInterceptor(interceptor.Unary()),
+			grpc.WithStreamInterceptor(interceptor.Stream()),
+		)
+	} else if !*enableMTLS {
+		authConn, err := grpc.NewClient(*serverAddr, transportOpts)
+		if err != nil {
+			log.Fatalf("cannot connect to server with address: %s. Error: (%v)", *serverAddr, err)
+		}
+		authClient := client.NewAuthClient(authConn, username, password)
+		interceptor, err := client.NewAuthIntercepter(parentCtx, authClient, authMethods(), refreshDuration)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dialOpts = append(dialOpts,
+			grpc.WithUnaryInterceptor(interceptor.Unary()),
+			grpc.WithStreamInterceptor(interceptor.Stream()),
+		)
 	}
-	conn, err := grpc.NewClient(
-		*serverAddr,
-		transportOpts,
-		grpc.WithUnaryInterceptor(interceptor.Unary()),
-		grpc.WithStreamInterceptor(interceptor.Stream()),
-	)
+	conn, err := grpc.NewClient(*serverAddr, dialOpts...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -62,7 +75,7 @@ func main() {
 	testRateLaptop(parentCtx, laptopClient)
 }
 
-func loadTLSCreds() (credentials.TransportCredentials, error) {
+func loadTLSCreds(enableMTLS bool) (credentials.TransportCredentials, error) {
 	pemServerCA, err := os.ReadFile(filepath.Join("cert", "ca-cert.pem"))
 	if err != nil {
 		return nil, err
@@ -75,15 +88,33 @@ func loadTLSCreds() (credentials.TransportCredentials, error) {
 	config := &tls.Config{
 		RootCAs: certPool,
 	}
+	if enableMTLS {
+		clientCert, err := tls.LoadX509KeyPair(
+			filepath.Join("cert", "client-cert.pem"),
+			filepath.Join("cert", "client-key.pem"),
+		)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
 	return credentials.NewTLS(config), nil
 }
 
 func authMethods() map[string]bool {
 	const laptopServicePath = "/pc.LaptopService/"
 	return map[string]bool{
-		fmt.Sprintf("%v%v", laptopServicePath, "CreateLaptop"): true,
-		fmt.Sprintf("%v%v", laptopServicePath, "UploadImage"):  true,
-		fmt.Sprintf("%v%v", laptopServicePath, "RateLaptop"):   true,
+		fmt.Sprintf("%v%v", laptopServicePath, "CreateLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "UpdateLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "UploadImage"):     true,
+		fmt.Sprintf("%v%v", laptopServicePath, "InitiateUpload"):  true,
+		fmt.Sprintf("%v%v", laptopServicePath, "QueryUpload"):     true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteImage"):     true,
+		fmt.Sprintf("%v%v", laptopServicePath, "SetPrimaryImage"): true,
+		fmt.Sprintf("%v%v", laptopServicePath, "ReorderImages"):   true,
+		fmt.Sprintf("%v%v", laptopServicePath, "RateLaptop"):      true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteMyRating"):  true,
 	}
 }
 
@@ -109,7 +140,10 @@ func testCreateNLaptopsAndSearchOneOf(ctx context.Context, client *client.Laptop
 func testUploadImage(ctx context.Context, client *client.LaptopClient) {
 	laptop := sample.NewLaptop()
 	client.CreateLaptop(ctx, laptop)
-	client.UploadImage(ctx, laptop.GetId(), "tmp/laptop.jpg")
+	_, err := client.UploadImage(ctx, laptop.GetId(), "tmp/laptop.jpg")
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func testRateLaptop(ctx context.Context,
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"main/config"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const gatewayBufferSize = 1 << 20
+
+// runCombinedServer serves gRPC and REST requests on one listener until the
+// context is done. gRPC requests are told apart by HTTP/2 and the gRPC
+// content type. The REST gateway calls the gRPC server through an in-memory
+// connection, so REST requests pass the same interceptors as in proxy mode.
+func runCombinedServer(
+	ctx context.Context,
+	grpcServer *grpc.Server,
+	healthServer *health.Server,
+	tlsConfig config.TLS,
+	listener net.Listener,
+	drainTimeout time.Duration,
+) error {
+	const op = "cmd.server.runCombinedServer"
+	gatewayListener := bufconn.Listen(gatewayBufferSize)
+	grpcErr := make(chan error, 1)
+	go func() {
+		grpcErr <- grpcServer.Serve(gatewayListener)
+	}()
+	defer func() {
+		grpcServer.Stop()
+		<-grpcErr
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///gateway",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return gatewayListener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot dial gRPC server: %w", err)
+	}
+	defer conn.Close()
+
+	// readiness fails while the server is draining
+	var draining atomic.Bool
+	mux, err := newRESTMux(ctx, conn, &draining)
+	if err != nil {
+		return err
+	}
+	handler := &combinedHandler{grpcServer: grpcServer, rest: mux}
+	server := &http.Server{Handler: handler}
+	if tlsConfig.Enabled {
+		server.TLSConfig, err = loadTLSConfig(tlsConfig)
+		if err != nil {
+			return fmt.Errorf("%v: cannot load TLS creds: %w", op, err)
+		}
+	} else {
+		// gRPC clients use HTTP/2 without TLS
+		server.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	log.Printf("%v: start GRPC and REST server at %v\n", op, listener.Addr().String())
+	serveErr := serveHTTP(server, listener)
+	select {
+	case err := <-serveErr:
+		return err
+	case err := <-grpcErr:
+		grpcErr <- err
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("%v: shutting down, drain timeout %v\n", op, drainTimeout)
+	healthServer.Shutdown()
+	draining.Store(true)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancelShutdown()
+	err = shutdownHTTP(shutdownCtx, server, serveErr)
+	if drainErr := handler.drain(shutdownCtx); drainErr != nil {
+		log.Printf("%v: drain timeout is exceeded, cancel in-flight gRPC requests\n", op)
+	}
+	return err
+}
+
+// combinedHandler routes gRPC requests to the gRPC server and the others to
+// the REST gateway. GracefulStop of the gRPC server does not support
+// requests served by ServeHTTP, neither does the HTTP server track
+// connections upgraded to h2c, so the handler drains gRPC requests itself.
+type combinedHandler struct {
+	grpcServer *grpc.Server
+	rest       http.Handler
+
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+}
+
+func (h *combinedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		h.rest.ServeHTTP(w, r)
+		return
+	}
+	if !h.begin() {
+		// trailers with the status let the client retry on another server
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "server is shutting down")
+		return
+	}
+	defer h.inflight.Done()
+	h.grpcServer.ServeHTTP(w, r)
+}
+
+// begin counts the gRPC request in, unless the handler is draining.
+func (h *combinedHandler) begin() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+// drain rejects new gRPC requests and waits for in-flight ones until the
+// context is done.
+func (h *combinedHandler) drain(ctx context.Context) error {
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
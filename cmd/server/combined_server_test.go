@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/config"
+	"main/pb"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type testCombinedServer struct {
+	addr   string
+	health *health.Server
+	stop   context.CancelFunc
+	runErr chan error
+}
+
+func startTestCombinedServer(t *testing.T, laptopServer *blockingLaptopServer) *testCombinedServer {
+	grpcServer, healthServer := newTestGRPCServer(laptopServer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	server := &testCombinedServer{
+		addr:   listener.Addr().String(),
+		health: healthServer,
+		stop:   cancel,
+		runErr: make(chan error, 1),
+	}
+	go func() {
+		server.runErr <- runCombinedServer(ctx, grpcServer, healthServer, config.TLS{}, listener, 10*time.Second)
+	}()
+	return server
+}
+
+func getReadiness(addr string) (int, string, error) {
+	res, err := http.Get(fmt.Sprintf("http://%s/readyz", addr))
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	var body map[string]string
+	err = json.NewDecoder(res.Body).Decode(&body)
+	return res.StatusCode, body["status"], err
+}
+
+func TestRunCombinedServer(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := newBlockingLaptopServer()
+	close(laptopServer.release)
+	server := startTestCombinedServer(t, laptopServer)
+
+	// gRPC and REST requests are served on the same port
+	conn := newTestConn(t, server.addr)
+	res, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.GetStatus())
+	created, err := pb.NewLaptopServiceClient(conn).CreateLaptop(context.Background(), &pb.CreateLaptopRequest{
+		Laptop: &pb.Laptop{Id: "laptop1"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "laptop1", created.GetId())
+
+	code, servingStatus, err := getReadiness(server.addr)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING.String(), servingStatus)
+	restRes, err := http.Post(fmt.Sprintf("http://%s/v1/laptop/create", server.addr), "application/json",
+		strings.NewReader(`{"laptop": {"id": "laptop2"}}`))
+	require.NoError(t, err)
+	defer restRes.Body.Close()
+	require.Equal(t, http.StatusOK, restRes.StatusCode)
+	var restCreated map[string]string
+	require.NoError(t, json.NewDecoder(restRes.Body).Decode(&restCreated))
+	require.Equal(t, "laptop2", restCreated["id"])
+
+	server.stop()
+	require.NoError(t, <-server.runErr)
+}
+
+func TestRunCombinedServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := newBlockingLaptopServer()
+	server := startTestCombinedServer(t, laptopServer)
+	callErr := startCreateLaptop(t, newTestConn(t, server.addr), laptopServer)
+	server.stop()
+	requireNotServing(t, server.health)
+
+	// new gRPC and REST requests are refused while the in-flight one is drained
+	require.Eventually(t, func() bool {
+		_, err := healthpb.NewHealthClient(newTestConn(t, server.addr)).Check(
+			context.Background(), &healthpb.HealthCheckRequest{})
+		return status.Code(err) == codes.Unavailable
+	}, time.Second, 10*time.Millisecond)
+	_, _, err := getReadiness(server.addr)
+	require.Error(t, err)
+	select {
+	case err := <-server.runErr:
+		t.Fatalf("server is stopped before the in-flight call is finished: %v", err)
+	default:
+	}
+
+	close(laptopServer.release)
+	require.NoError(t, <-callErr)
+	require.NoError(t, <-server.runErr)
+}
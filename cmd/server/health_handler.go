@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// healthHandler reports the health state of the gRPC server. Liveness
+// succeeds if the gRPC server answers, readiness only if the service given
+// by the service query parameter, all services by default, is serving and
+// the REST server is not draining.
+func healthHandler(healthClient healthpb.HealthClient, readiness bool, draining *atomic.Bool) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		code := http.StatusOK
+		resp, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{
+			Service: r.URL.Query().Get("service"),
+		})
+		servingStatus := resp.GetStatus().String()
+		switch {
+		case status.Code(err) == codes.NotFound:
+			code = http.StatusNotFound
+			servingStatus = healthpb.HealthCheckResponse_SERVICE_UNKNOWN.String()
+		case err != nil:
+			code = http.StatusServiceUnavailable
+			servingStatus = healthpb.HealthCheckResponse_UNKNOWN.String()
+		case readiness && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING:
+			code = http.StatusServiceUnavailable
+		case readiness && draining.Load():
+			code = http.StatusServiceUnavailable
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING.String()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"status": servingStatus})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"log"
+	"main/pb"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const downloadImagePattern = "/v1/laptop/image/{image_id}"
+
+// downloadImageHandler serves raw image bytes received from
+// DownloadImage stream, since gateway can only stream JSON messages.
+func downloadImageHandler(mux *runtime.ServeMux, laptopClient pb.LaptopServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		const op = "cmd.server.downloadImageHandler"
+		_, outboundMarshaler := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(
+			r.Context(),
+			mux,
+			r,
+			"/pc.LaptopService/DownloadImage",
+			runtime.WithHTTPPathPattern(downloadImagePattern),
+		)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, err)
+			return
+		}
+
+		req := &pb.DownloadImageRequest{ImageId: pathParams["image_id"]}
+		if size := r.URL.Query().Get("size"); len(size) > 0 {
+			n, err := strconv.ParseUint(size, 10, 32)
+			if err != nil {
+				runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, status.Errorf(codes.InvalidArgument, "invalid size %q", size))
+				return
+			}
+			req.Size = uint32(n)
+		}
+		stream, err := laptopClient.DownloadImage(ctx, req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, err)
+			return
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, err)
+			return
+		}
+		info := resp.GetInfo()
+		contentType := mime.TypeByExtension(info.GetImageType())
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatUint(info.GetByteSize(), 10))
+		w.WriteHeader(http.StatusOK)
+
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				log.Printf("%v: cannot receive chunk of image %v: %v", op, info.GetId(), err)
+				return
+			}
+			_, err = w.Write(resp.GetChunkData())
+			if err != nil {
+				log.Printf("%v: cannot write chunk of image %v: %v", op, info.GetId(), err)
+				return
+			}
+		}
+	}
+}
@@ -3,136 +3,440 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"main/audit"
+	"main/blob"
+	"main/config"
 	"main/models"
 	"main/pb"
 	"main/service"
 	"main/storage"
 	"net"
 	"net/http"
-	"path/filepath"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-)
-
-// TODO: secret in .env
-const (
-	jwtKey = "secret"
-	TTL    = 15 * time.Minute
-)
-
-var (
-	serverCertFile       = filepath.Join("cert", "server-cert.pem")
-	serverPriviteKeyFile = filepath.Join("cert", "server-key.pem")
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	ctx := context.Background()
 	const op = "cmd.server.main"
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	port := flag.Int("port", 0, "the server port")
-	enableTLS := flag.Bool("tsl", false, "enable SSL/TLS")
-	serverType := flag.String("type", "grpc", "type os server: grpc/rest")
-	grpcEndpoint := flag.String("endpoint", "", "gRPC endpoint")
-
-	flag.Parse()
-	log.Printf("%v: starting grpc server, TLS: %v\n", op, *enableTLS)
+	cfg, opts, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("%v: cannot load config: (%v)", op, err)
+	}
+	if opts.PrintConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			log.Fatalf("%v: cannot print config: (%v)", op, err)
+		}
+	}
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatalf("%v: invalid config: (%v)", op, err)
+	}
+	if opts.PrintConfig {
+		return
+	}
+	if cfg.Auth.JWTSecret == config.DefaultJWTSecret {
+		log.Printf("%v: default JWT secret is used in development mode\n", op)
+	}
+	log.Printf("%v: starting %v server, TLS: %v, mTLS: %v\n", op, cfg.Server.Type, cfg.TLS.Enabled, cfg.TLS.MTLS)
+	passwordHasher, err := newPasswordHasher(cfg.Auth.PasswordHash, cfg.Auth.BcryptCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordPolicy, err := newPasswordPolicy(cfg.Auth.PasswordPolicy)
+	if err != nil {
+		log.Fatalf("%v: invalid password policy config: (%v)", op, err)
+	}
 	laptopStorage := storage.NewInMemoryLaptopStorage()
-	imageStorage := storage.NewImageStorage("img")
+	imageBlobStore, err := newBlobStore(cfg.Storage)
+	if err != nil {
+		log.Fatalf("%v: invalid blob store config: (%v)", op, err)
+	}
+	imageStorage := storage.NewImageStorage(imageBlobStore)
 	ratingStorage := storage.NewRatingStorage()
 	userStorage := storage.NewUserStorage()
-	err := seedUsers(userStorage)
+	apiKeyStorage := storage.NewAPIKeyStorage()
+	tenantStorage := storage.NewTenantStorage()
+	reviewStorage := storage.NewReviewStorage()
+	if len(cfg.Storage.DataDir) > 0 {
+		imageStorage, err = storage.OpenImageStorage(imageBlobStore, cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open image storage: (%v)", op, err)
+		}
+		ratingStorage, err = storage.OpenRatingStorage(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open rating storage: (%v)", op, err)
+		}
+		userStorage, err = storage.OpenUserStorage(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open user storage: (%v)", op, err)
+		}
+		apiKeyStorage, err = storage.OpenAPIKeyStorage(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open API key storage: (%v)", op, err)
+		}
+		tenantStorage, err = storage.OpenTenantStorage(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open tenant storage: (%v)", op, err)
+		}
+		reviewStorage, err = storage.OpenReviewStorage(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("%v: cannot open review storage: (%v)", op, err)
+		}
+	}
+	defer ratingStorage.Close()
+	defer userStorage.Close()
+	defer apiKeyStorage.Close()
+	defer tenantStorage.Close()
+	defer reviewStorage.Close()
+	err = seedTenants(tenantStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = seedUsers(userStorage, passwordHasher)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("%v: users created\n", op)
-	jwtManager := service.NewJWTManager(jwtKey, TTL)
-	authServer := service.NewAuthServer(userStorage, jwtManager)
-	laptopServer := service.NewLaptopServer(laptopStorage, imageStorage, ratingStorage)
+	auditLogger, err := audit.NewLogger(cfg.Audit.Log)
+	if err != nil {
+		log.Fatalf("%v: cannot open audit log: (%v)", op, err)
+	}
+	defer auditLogger.Close()
+	jwtManager := service.NewJWTManager(cfg.Auth.JWTSecret, cfg.Auth.TokenTTL)
+	authServer := service.NewAuthServer(userStorage, apiKeyStorage, jwtManager, auditLogger, passwordHasher, passwordPolicy)
+	imageValidator, err := service.NewImageValidator(cfg.Images.Formats, cfg.Images.MaxWidth, cfg.Images.MaxHeight, cfg.Images.MaxPixels)
+	if err != nil {
+		log.Fatalf("%v: invalid image config: (%v)", op, err)
+	}
+	thumbnailer, err := newThumbnailer(cfg.Images.ThumbnailSizes)
+	if err != nil {
+		log.Fatalf("%v: invalid thumbnail config: (%v)", op, err)
+	}
+	quotaPolicy := newQuotaPolicy(service.UploadLimits{
+		MaxImageSize:       cfg.Images.MaxSize,
+		MaxImagesPerLaptop: cfg.Images.MaxPerLaptop,
+		StorageQuota:       cfg.Images.StorageQuota,
+	}, cfg.Images.RoleLimits)
+	ratingScale, err := service.NewRatingScale(cfg.Rating.Min, cfg.Rating.Max, cfg.Rating.Step)
+	if err != nil {
+		log.Fatalf("%v: invalid rating scale config: (%v)", op, err)
+	}
+	uploadStorage := storage.NewUploadStorage(cfg.Uploads.Dir, cfg.Uploads.TTL, cfg.Uploads.MaxPerUser)
+	laptopServer := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{
+		ImageStorage:   imageStorage,
+		RatingStorage:  ratingStorage,
+		ReviewStorage:  reviewStorage,
+		UploadStorage:  uploadStorage,
+		ImageValidator: imageValidator,
+		Thumbnailer:    thumbnailer,
+		QuotaPolicy:    quotaPolicy,
+		RatingScale:    ratingScale,
+	})
+	reviewServer := service.NewReviewServer(reviewStorage, laptopStorage, ratingStorage, ratingScale)
+	tenantServer := service.NewTenantServer(tenantStorage, userStorage)
+	auditServer := service.NewAuditServer(auditLogger)
+
+	healthServer := health.NewServer()
+	healthMonitor := service.NewHealthMonitor(healthServer, map[string][]service.ReadinessCheck{
+		pb.AuthService_ServiceDesc.ServiceName:   {userStorage.Check, apiKeyStorage.Check},
+		pb.LaptopService_ServiceDesc.ServiceName: {imageStorage.Check, ratingStorage.Check},
+		pb.ReviewService_ServiceDesc.ServiceName: {reviewStorage.Check, ratingStorage.Check},
+		pb.TenantService_ServiceDesc.ServiceName: {userStorage.Check, tenantStorage.Check},
+		pb.AuditService_ServiceDesc.ServiceName:  {},
+	})
 
-	addr := fmt.Sprintf("0.0.0.0:%d", *port)
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.Server.Port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("%v: cannot start server: (%v)", op, err)
 	}
-	if *serverType == "grpc" {
-		err = runGRPCServer(authServer, laptopServer, jwtManager, *enableTLS, listener)
-	} else {
-		err = runRESTServer(ctx, authServer, laptopServer, *enableTLS, listener, *grpcEndpoint)
+	services := grpcServices{
+		auth:   authServer,
+		laptop: laptopServer,
+		review: reviewServer,
+		tenant: tenantServer,
+		audit:  auditServer,
+		health: healthServer,
+	}
+	switch cfg.Server.Type {
+	case "grpc":
+		healthMonitor.Update(ctx)
+		go healthMonitor.Run(ctx, cfg.Server.HealthInterval)
+		var serverOpts []grpc.ServerOption
+		if cfg.TLS.Enabled {
+			tlsConfig, err := loadTLSConfig(cfg.TLS)
+			if err != nil {
+				log.Fatalf("%v: cannot load TLS creds: (%v)", op, err)
+			}
+			serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer := newGRPCServer(services, jwtManager, apiKeyStorage, userStorage, auditLogger, cfg.TLS.MTLS, cfg.Server.Reflection, serverOpts...)
+		err = runGRPCServer(ctx, grpcServer, healthServer, listener, cfg.Server.DrainTimeout)
+	case "rest":
+		err = runRESTServer(ctx, cfg.TLS, listener, cfg.Server.Endpoint, cfg.Server.DrainTimeout)
+	case "combined":
+		healthMonitor.Update(ctx)
+		go healthMonitor.Run(ctx, cfg.Server.HealthInterval)
+		grpcServer := newGRPCServer(services, jwtManager, apiKeyStorage, userStorage, auditLogger, cfg.TLS.MTLS, cfg.Server.Reflection)
+		err = runCombinedServer(ctx, grpcServer, healthServer, cfg.TLS, listener, cfg.Server.DrainTimeout)
+	default:
+		err = fmt.Errorf("unknown server type %q", cfg.Server.Type)
 	}
 	if err != nil {
 		log.Fatalf("op: %v, error: %v", op, err)
 	}
+	log.Printf("%v: server is stopped\n", op)
 }
 
-func runGRPCServer(
-	authServer pb.AuthServiceServer,
-	laptopServer pb.LaptopServiceServer,
+// grpcServices are the services registered in the gRPC server.
+type grpcServices struct {
+	auth   pb.AuthServiceServer
+	laptop pb.LaptopServiceServer
+	review pb.ReviewServiceServer
+	tenant pb.TenantServiceServer
+	audit  pb.AuditServiceServer
+	health *health.Server
+}
+
+// newGRPCServer creates the gRPC server with the services and the auth and
+// audit interceptors.
+func newGRPCServer(
+	services grpcServices,
 	jwtManager *service.JWTManager,
-	enableTLS bool,
+	apiKeyStorage service.APIKeyStorager,
+	userStorage service.UserStorager,
+	auditLogger service.AuditLogger,
+	enableMTLS bool,
+	enableReflection bool,
+	serverOpts ...grpc.ServerOption,
+) *grpc.Server {
+	var certMapper *service.CertIdentityMapper
+	if enableMTLS {
+		certMapper = service.NewCertIdentityMapper(userStorage)
+	}
+	interceptor := service.NewAuthInterceptor(jwtManager, apiKeyStorage, certMapper, accessiblePermissions(), auditLogger)
+	auditInterceptor := service.NewAuditInterceptor(auditLogger, auditedMethods())
+
+	serverOpts = append(serverOpts,
+		grpc.ChainUnaryInterceptor(interceptor.Unary(), auditInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(interceptor.Stream(), auditInterceptor.Stream()),
+	)
+	grpcServer := grpc.NewServer(serverOpts...)
+	pb.RegisterAuthServiceServer(grpcServer, services.auth)
+	pb.RegisterLaptopServiceServer(grpcServer, services.laptop)
+	pb.RegisterReviewServiceServer(grpcServer, services.review)
+	pb.RegisterTenantServiceServer(grpcServer, services.tenant)
+	pb.RegisterAuditServiceServer(grpcServer, services.audit)
+	healthpb.RegisterHealthServer(grpcServer, services.health)
+	if enableReflection {
+		reflection.Register(grpcServer)
+	}
+	return grpcServer
+}
+
+// runGRPCServer serves until the context is done. Then the health of all
+// services is set to NOT_SERVING and in-flight requests are given
+// drainTimeout to finish before they are cancelled.
+func runGRPCServer(
+	ctx context.Context,
+	grpcServer *grpc.Server,
+	healthServer *health.Server,
 	listener net.Listener,
+	drainTimeout time.Duration,
 ) error {
 	const op = "cmd.server.runGRPCServer"
-	interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
-
-	serverOpts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(interceptor.Unary()),
-		grpc.StreamInterceptor(interceptor.Stream()),
+	log.Printf("%v: start GRPC server at %v\n", op, listener.Addr().String())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(listener)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
 
-	if enableTLS {
-		tlsCreds, err := loadTLSCreds()
-		if err != nil {
-			return fmt.Errorf("%v: cannot load TLS creds: %w", op, err)
-		}
-		serverOpts = append(serverOpts, grpc.Creds(tlsCreds))
+	log.Printf("%v: shutting down, drain timeout %v\n", op, drainTimeout)
+	healthServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		log.Printf("%v: drain timeout is exceeded, cancel in-flight requests\n", op)
+		grpcServer.Stop()
+		<-stopped
 	}
-
-	grpcServer := grpc.NewServer(serverOpts...)
-	pb.RegisterAuthServiceServer(grpcServer, authServer)
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
-	log.Printf("%v: start GRPC server at %v, TLS: %t\n", op, listener.Addr().String(), enableTLS)
-	return grpcServer.Serve(listener)
+	return <-serveErr
 }
 
+// runRESTServer serves the REST gateway of the gRPC server at grpcEndpoint
+// until the context is done.
 func runRESTServer(
 	ctx context.Context,
-	authServer pb.AuthServiceServer,
-	laptopServer pb.LaptopServiceServer,
-	enableTLS bool,
+	tlsConfig config.TLS,
 	listener net.Listener,
 	grpcEndpoint string,
+	drainTimeout time.Duration,
 ) error {
-	mux := runtime.NewServeMux()
-	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	const op = "cmd.server.runRESTServer"
+	conn, err := grpc.NewClient(grpcEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("cannot dial gRPC endpoint: %w", err)
+	}
+	defer conn.Close()
 
-	// err := pb.RegisterAuthServiceHandlerServer(ctx, mux, authServer)
-	err := pb.RegisterAuthServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOpts)
+	// readiness fails while the server is draining
+	var draining atomic.Bool
+	mux, err := newRESTMux(ctx, conn, &draining)
 	if err != nil {
-		return nil
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	if tlsConfig.Enabled {
+		// client certificates are verified by the gRPC server only
+		tlsConfig.MTLS = false
+		server.TLSConfig, err = loadTLSConfig(tlsConfig)
+		if err != nil {
+			return fmt.Errorf("%v: cannot load TLS creds: %w", op, err)
+		}
 	}
+	log.Printf("%v: start REST server at %v\n", op, listener.Addr().String())
+	serveErr := serveHTTP(server, listener)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("%v: shutting down, drain timeout %v\n", op, drainTimeout)
+	draining.Store(true)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancelShutdown()
+	return shutdownHTTP(shutdownCtx, server, serveErr)
+}
+
+// newRESTMux creates the REST gateway which forwards requests to the gRPC
+// server by the connection. Handlers use the connection after the context
+// is done until in-flight requests are drained.
+func newRESTMux(ctx context.Context, conn *grpc.ClientConn, draining *atomic.Bool) (*runtime.ServeMux, error) {
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher))
+	ctx = context.WithoutCancel(ctx)
 
-	err = pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOpts)
+	err := pb.RegisterAuthServiceHandler(ctx, mux, conn)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("cannot register auth service handler: %w", err)
+	}
+
+	err = pb.RegisterLaptopServiceHandler(ctx, mux, conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register laptop service handler: %w", err)
+	}
+
+	err = pb.RegisterReviewServiceHandler(ctx, mux, conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register review service handler: %w", err)
+	}
+
+	err = pb.RegisterTenantServiceHandler(ctx, mux, conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register tenant service handler: %w", err)
+	}
+
+	err = pb.RegisterAuditServiceHandler(ctx, mux, conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register audit service handler: %w", err)
+	}
+
+	err = mux.HandlePath(http.MethodGet, downloadImagePattern, downloadImageHandler(mux, pb.NewLaptopServiceClient(conn)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot register download image handler: %w", err)
+	}
+	healthClient := healthpb.NewHealthClient(conn)
+	err = mux.HandlePath(http.MethodGet, "/healthz", healthHandler(healthClient, false, draining))
+	if err != nil {
+		return nil, fmt.Errorf("cannot register health handler: %w", err)
+	}
+	err = mux.HandlePath(http.MethodGet, "/readyz", healthHandler(healthClient, true, draining))
+	if err != nil {
+		return nil, fmt.Errorf("cannot register readiness handler: %w", err)
 	}
-	log.Printf("start REST server at %v, TLS: %t\n", listener.Addr().String(), enableTLS)
-	if enableTLS {
-		return http.ServeTLS(listener, mux, serverCertFile, serverPriviteKeyFile)
+	return mux, nil
+}
+
+// serveHTTP serves in the background and returns the channel of the serve
+// error. TLS is enabled by the TLS config of the server.
+func serveHTTP(server *http.Server, listener net.Listener) <-chan error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+			return
+		}
+		serveErr <- server.Serve(listener)
+	}()
+	return serveErr
+}
+
+// shutdownHTTP waits for in-flight requests until the context is done and
+// then closes the remaining connections.
+func shutdownHTTP(ctx context.Context, server *http.Server, serveErr <-chan error) error {
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("drain timeout is exceeded, close connections\n")
+		err = server.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot shut down REST server: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// incomingHeaderMatcher forwards API key header to gRPC server
+// in addition to the default headers.
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, "x-api-key") {
+		return "x-api-key", true
 	}
-	return http.Serve(listener, mux)
+	return runtime.DefaultHeaderMatcher(key)
 }
 
-func loadTLSCreds() (credentials.TransportCredentials, error) {
-	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverPriviteKeyFile)
+// loadTLSConfig loads the server certificate and, if mTLS is enabled,
+// the CA certificate to verify client certificates.
+func loadTLSConfig(tlsConfig config.TLS) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
 	if err != nil {
 		return nil, err
 	}
@@ -141,28 +445,196 @@ func loadTLSCreds() (credentials.TransportCredentials, error) {
 		Certificates: []tls.Certificate{serverCert},
 		ClientAuth:   tls.NoClientCert,
 	}
-	return credentials.NewTLS(config), nil
+	if tlsConfig.MTLS {
+		pemClientCA, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pemClientCA) {
+			return nil, fmt.Errorf("failed to add client CA's certificate")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = certPool
+	}
+	return config, nil
+}
+
+func accessiblePermissions() map[string]string {
+	const laptopServicePath = "/pc.LaptopService/"
+	const authServicePath = "/pc.AuthService/"
+	const reviewServicePath = "/pc.ReviewService/"
+	const tenantServicePath = "/pc.TenantService/"
+	const auditServicePath = "/pc.AuditService/"
+	return map[string]string{
+		fmt.Sprintf("%v%v", laptopServicePath, "CreateLaptop"):        service.PermissionLaptopCreate,
+		fmt.Sprintf("%v%v", laptopServicePath, "UpdateLaptop"):        service.PermissionLaptopUpdate,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteLaptop"):        service.PermissionLaptopUpdate,
+		fmt.Sprintf("%v%v", laptopServicePath, "UploadImage"):         service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "InitiateUpload"):      service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "QueryUpload"):         service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteImage"):         service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "SetPrimaryImage"):     service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "ReorderImages"):       service.PermissionImageUpload,
+		fmt.Sprintf("%v%v", laptopServicePath, "RateLaptop"):          service.PermissionRatingWrite,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteMyRating"):      service.PermissionRatingWrite,
+		fmt.Sprintf("%v%v", reviewServicePath, "SubmitReview"):        service.PermissionRatingWrite,
+		fmt.Sprintf("%v%v", reviewServicePath, "ListModerationQueue"): service.PermissionReviewModerate,
+		fmt.Sprintf("%v%v", reviewServicePath, "ModerateReview"):      service.PermissionReviewModerate,
+		fmt.Sprintf("%v%v", authServicePath, "CreateApiKey"):          service.PermissionUserAdmin,
+		fmt.Sprintf("%v%v", authServicePath, "ListApiKeys"):           service.PermissionUserAdmin,
+		fmt.Sprintf("%v%v", authServicePath, "RevokeApiKey"):          service.PermissionUserAdmin,
+		fmt.Sprintf("%v%v", tenantServicePath, "CreateTenant"):        service.PermissionTenantAdmin,
+		fmt.Sprintf("%v%v", tenantServicePath, "ListTenants"):         service.PermissionTenantAdmin,
+		fmt.Sprintf("%v%v", tenantServicePath, "AssignUser"):          service.PermissionTenantAdmin,
+		fmt.Sprintf("%v%v", auditServicePath, "QueryAuditLog"):        service.PermissionUserAdmin,
+	}
 }
 
-func accessibleRoles() map[string][]string {
+func auditedMethods() map[string]bool {
 	const laptopServicePath = "/pc.LaptopService/"
-	return map[string][]string{
-		fmt.Sprintf("%v%v", laptopServicePath, "CreateLaptop"): {"admin"},
-		fmt.Sprintf("%v%v", laptopServicePath, "UploadImage"):  {"admin"},
-		fmt.Sprintf("%v%v", laptopServicePath, "RateLaptop"):   {"admin", "user"},
+	const authServicePath = "/pc.AuthService/"
+	const reviewServicePath = "/pc.ReviewService/"
+	const tenantServicePath = "/pc.TenantService/"
+	return map[string]bool{
+		fmt.Sprintf("%v%v", laptopServicePath, "CreateLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "UpdateLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteLaptop"):    true,
+		fmt.Sprintf("%v%v", laptopServicePath, "UploadImage"):     true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteImage"):     true,
+		fmt.Sprintf("%v%v", laptopServicePath, "SetPrimaryImage"): true,
+		fmt.Sprintf("%v%v", laptopServicePath, "ReorderImages"):   true,
+		fmt.Sprintf("%v%v", laptopServicePath, "RateLaptop"):      true,
+		fmt.Sprintf("%v%v", laptopServicePath, "DeleteMyRating"):  true,
+		fmt.Sprintf("%v%v", reviewServicePath, "SubmitReview"):    true,
+		fmt.Sprintf("%v%v", reviewServicePath, "ModerateReview"):  true,
+		fmt.Sprintf("%v%v", authServicePath, "Register"):          true,
+		fmt.Sprintf("%v%v", authServicePath, "ChangePassword"):    true,
+		fmt.Sprintf("%v%v", authServicePath, "CreateApiKey"):      true,
+		fmt.Sprintf("%v%v", authServicePath, "RevokeApiKey"):      true,
+		fmt.Sprintf("%v%v", tenantServicePath, "CreateTenant"):    true,
+		fmt.Sprintf("%v%v", tenantServicePath, "AssignUser"):      true,
 	}
 }
 
-func seedUsers(userStorage service.UserStorager) error {
-	err := createUser(userStorage, "admin", "passwordQWERTY1", "admin")
+func seedTenants(tenantStorage service.TenantStorager) error {
+	existing, err := tenantStorage.Get(models.DefaultTenantID)
 	if err != nil {
 		return err
 	}
-	return createUser(userStorage, "user1", "pass2", "user")
+	// tenants are kept in the data folder between restarts
+	if existing != nil {
+		return nil
+	}
+	return tenantStorage.Save(&models.Tenant{
+		ID:        models.DefaultTenantID,
+		Name:      "Default",
+		CreatedAt: time.Now(),
+	})
+}
+
+func newPasswordHasher(algorithm string, bcryptCost int) (models.PasswordHasher, error) {
+	switch algorithm {
+	case "bcrypt":
+		return models.NewBcryptHasher(bcryptCost), nil
+	case "argon2id":
+		return models.NewArgon2idHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %v", algorithm)
+	}
+}
+
+// newPasswordPolicy reads the denylist file, which has one password per
+// line. Empty lines and lines starting with # are skipped.
+func newPasswordPolicy(cfg config.PasswordPolicy) (*models.PasswordPolicy, error) {
+	rules := models.PasswordRules{MinLength: cfg.MinLength}
+	for _, class := range cfg.Require {
+		switch class {
+		case "upper":
+			rules.RequireUpper = true
+		case "lower":
+			rules.RequireLower = true
+		case "digit":
+			rules.RequireDigit = true
+		case "symbol":
+			rules.RequireSymbol = true
+		default:
+			return nil, fmt.Errorf("unknown password character class %q", class)
+		}
+	}
+	if len(cfg.DenylistFile) > 0 {
+		data, err := os.ReadFile(cfg.DenylistFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read password denylist: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) > 0 && !strings.HasPrefix(line, "#") {
+				rules.Denylist = append(rules.Denylist, line)
+			}
+		}
+	}
+	return models.NewPasswordPolicy(rules), nil
+}
+
+func newThumbnailer(sizes []uint32) (*service.Thumbnailer, error) {
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+	return service.NewThumbnailer(sizes)
+}
+
+func newQuotaPolicy(defaults service.UploadLimits, roleLimits config.RoleLimits) *service.QuotaPolicy {
+	roles := make(map[string]service.UploadLimits, len(roleLimits))
+	for role, limits := range roleLimits {
+		roles[role] = service.UploadLimits{
+			MaxImageSize:       limits.MaxImageSize,
+			MaxImagesPerLaptop: limits.MaxImagesPerLaptop,
+			StorageQuota:       limits.StorageQuota,
+		}
+	}
+	return service.NewQuotaPolicy(defaults, roles)
 }
 
-func createUser(userStorage service.UserStorager, username, password, role string) error {
-	user, err := models.NewUser(username, password, role)
+func newBlobStore(cfg config.Storage) (blob.Store, error) {
+	switch cfg.Blob {
+	case "local":
+		return blob.NewLocalStore(cfg.BlobDir), nil
+	case "memory":
+		return blob.NewMemoryStore(), nil
+	case "s3":
+		return blob.NewS3Store(cfg.S3.Endpoint, cfg.S3.Region, cfg.S3.Bucket, cfg.S3.AccessKey, cfg.S3.SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store %v", cfg.Blob)
+	}
+}
+
+func seedUsers(userStorage service.UserStorager, hasher models.PasswordHasher) error {
+	err := createUser(userStorage, hasher, "root", "passwordROOT1", "superadmin")
+	if err != nil {
+		return err
+	}
+	err = createUser(userStorage, hasher, "admin", "passwordQWERTY1", "admin")
+	if err != nil {
+		return err
+	}
+	err = createUser(userStorage, hasher, "vendor1", "passwordVENDOR1", "vendor")
+	if err != nil {
+		return err
+	}
+	return createUser(userStorage, hasher, "user1", "passwordUSER2", "user")
+}
+
+func createUser(userStorage service.UserStorager, hasher models.PasswordHasher, username, password, role string) error {
+	existing, err := userStorage.Get(username)
+	if err != nil {
+		return err
+	}
+	// users are kept in the data folder between restarts
+	if existing != nil {
+		return nil
+	}
+	user, err := models.NewUserWithHasher(username, password, role, hasher)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"main/pb"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// blockingLaptopServer holds CreateLaptop calls until they are released.
+// The first call is reported to started.
+type blockingLaptopServer struct {
+	pb.UnimplementedLaptopServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingLaptopServer() *blockingLaptopServer {
+	return &blockingLaptopServer{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *blockingLaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-s.release:
+		return &pb.CreateLaptopResponse{Id: req.GetLaptop().GetId()}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func newTestGRPCServer(laptopServer pb.LaptopServiceServer) (*grpc.Server, *health.Server) {
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	return grpcServer, healthServer
+}
+
+func newTestConn(t *testing.T, addr string) *grpc.ClientConn {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startCreateLaptop calls CreateLaptop and waits until the server receives it.
+func startCreateLaptop(t *testing.T, conn *grpc.ClientConn, laptopServer *blockingLaptopServer) <-chan error {
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := pb.NewLaptopServiceClient(conn).CreateLaptop(context.Background(), &pb.CreateLaptopRequest{
+			Laptop: &pb.Laptop{Id: "laptop1"},
+		})
+		callErr <- err
+	}()
+	select {
+	case <-laptopServer.started:
+	case err := <-callErr:
+		t.Fatalf("call is finished before it is blocked: %v", err)
+	}
+	return callErr
+}
+
+func requireNotServing(t *testing.T, healthServer *health.Server) {
+	require.Eventually(t, func() bool {
+		res, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err == nil && res.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRunGRPCServerDrain(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := newBlockingLaptopServer()
+	grpcServer, healthServer := newTestGRPCServer(laptopServer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- runGRPCServer(ctx, grpcServer, healthServer, listener, 10*time.Second)
+	}()
+
+	callErr := startCreateLaptop(t, newTestConn(t, listener.Addr().String()), laptopServer)
+	cancel()
+	requireNotServing(t, healthServer)
+
+	// new calls are refused while the in-flight one is drained
+	require.Eventually(t, func() bool {
+		_, err := healthpb.NewHealthClient(newTestConn(t, listener.Addr().String())).Check(
+			context.Background(), &healthpb.HealthCheckRequest{})
+		return status.Code(err) == codes.Unavailable
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case err := <-runErr:
+		t.Fatalf("server is stopped before the in-flight call is finished: %v", err)
+	default:
+	}
+
+	close(laptopServer.release)
+	require.NoError(t, <-callErr)
+	require.NoError(t, <-runErr)
+}
+
+func TestRunGRPCServerDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := newBlockingLaptopServer()
+	grpcServer, healthServer := newTestGRPCServer(laptopServer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- runGRPCServer(ctx, grpcServer, healthServer, listener, 100*time.Millisecond)
+	}()
+
+	callErr := startCreateLaptop(t, newTestConn(t, listener.Addr().String()), laptopServer)
+	cancel()
+
+	// the call is never released, so it is cancelled after the drain timeout
+	require.NoError(t, <-runErr)
+	require.Error(t, <-callErr)
+	requireNotServing(t, healthServer)
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"main/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables of the configuration.
+// The variable of a flag is its name in upper case with underscores,
+// e.g. PCBOOK_DRAIN_TIMEOUT for -drain-timeout.
+const EnvPrefix = "PCBOOK_"
+
+// DefaultJWTSecret is the JWT secret used when none is configured, it is
+// only accepted in development mode.
+const DefaultJWTSecret = "secret"
+
+const redacted = "REDACTED"
+
+// legacyEnv are environment variables read before the prefixed ones.
+var legacyEnv = map[string]string{
+	"s3-access-key": "S3_ACCESS_KEY",
+	"s3-secret-key": "S3_SECRET_KEY",
+}
+
+// deprecatedFlags maps deprecated flags to the flags replacing them.
+var deprecatedFlags = map[string]string{
+	"tsl": "tls",
+}
+
+// Config is the configuration of the server.
+type Config struct {
+	Server  Server  `yaml:"server"`
+	TLS     TLS     `yaml:"tls"`
+	Auth    Auth    `yaml:"auth"`
+	Audit   Audit   `yaml:"audit"`
+	Images  Images  `yaml:"images"`
+	Uploads Uploads `yaml:"uploads"`
+	Rating  Rating  `yaml:"rating"`
+	Storage Storage `yaml:"storage"`
+}
+
+type Server struct {
+	// Type is grpc, rest or combined
+	Type           string        `yaml:"type"`
+	Port           int           `yaml:"port"`
+	Endpoint       string        `yaml:"endpoint"`
+	Reflection     bool          `yaml:"reflection"`
+	DrainTimeout   time.Duration `yaml:"drain-timeout"`
+	HealthInterval time.Duration `yaml:"health-interval"`
+	// Dev allows settings which are only safe in development,
+	// e.g. the default JWT secret.
+	Dev bool `yaml:"dev"`
+}
+
+type TLS struct {
+	Enabled      bool   `yaml:"enabled"`
+	MTLS         bool   `yaml:"mtls"`
+	CertFile     string `yaml:"cert-file"`
+	KeyFile      string `yaml:"key-file"`
+	ClientCAFile string `yaml:"client-ca-file"`
+}
+
+type Auth struct {
+	JWTSecret      string         `yaml:"jwt-secret"`
+	TokenTTL       time.Duration  `yaml:"token-ttl"`
+	PasswordHash   string         `yaml:"password-hash"`
+	BcryptCost     int            `yaml:"bcrypt-cost"`
+	PasswordPolicy PasswordPolicy `yaml:"password-policy"`
+}
+
+type PasswordPolicy struct {
+	MinLength int `yaml:"min-length"`
+	// Require are the character classes: upper, lower, digit or symbol
+	Require List `yaml:"require"`
+	// DenylistFile has rejected passwords, one per line
+	DenylistFile string `yaml:"denylist-file"`
+}
+
+type Audit struct {
+	Log string `yaml:"log"`
+}
+
+type Images struct {
+	Formats        List       `yaml:"formats"`
+	MaxWidth       int        `yaml:"max-width"`
+	MaxHeight      int        `yaml:"max-height"`
+	MaxPixels      int64      `yaml:"max-pixels"`
+	ThumbnailSizes Sizes      `yaml:"thumbnail-sizes"`
+	MaxSize        int64      `yaml:"max-size"`
+	MaxPerLaptop   int        `yaml:"max-per-laptop"`
+	StorageQuota   int64      `yaml:"storage-quota"`
+	RoleLimits     RoleLimits `yaml:"role-limits"`
+}
+
+type Uploads struct {
+	Dir        string        `yaml:"dir"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxPerUser int           `yaml:"max-per-user"`
+}
+
+type Rating struct {
+	Min  float64 `yaml:"min"`
+	Max  float64 `yaml:"max"`
+	Step float64 `yaml:"step"`
+}
+
+type Storage struct {
+	// DataDir is empty to keep data in memory only
+	DataDir string `yaml:"data-dir"`
+	// Blob is local, memory or s3
+	Blob    string `yaml:"blob"`
+	BlobDir string `yaml:"blob-dir"`
+	S3      S3     `yaml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access-key"`
+	SecretKey string `yaml:"secret-key"`
+}
+
+// Options are command line options which are not a part of the configuration.
+type Options struct {
+	// File is the path of the configuration file, also set by PCBOOK_CONFIG
+	File string
+	// PrintConfig asks to print the configuration and exit
+	PrintConfig bool
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Type:           "grpc",
+			DrainTimeout:   30 * time.Second,
+			HealthInterval: 10 * time.Second,
+		},
+		TLS: TLS{
+			CertFile:     filepath.Join("cert", "server-cert.pem"),
+			KeyFile:      filepath.Join("cert", "server-key.pem"),
+			ClientCAFile: filepath.Join("cert", "ca-cert.pem"),
+		},
+		Auth: Auth{
+			JWTSecret:    DefaultJWTSecret,
+			TokenTTL:     15 * time.Minute,
+			PasswordHash: "bcrypt",
+			BcryptCost:   bcrypt.DefaultCost,
+			PasswordPolicy: PasswordPolicy{
+				MinLength: 10,
+				Require:   List{"upper", "lower", "digit"},
+			},
+		},
+		Audit: Audit{
+			Log: "audit.log",
+		},
+		Images: Images{
+			Formats:        List{"jpeg", "png", "webp"},
+			MaxWidth:       8192,
+			MaxHeight:      8192,
+			MaxPixels:      40_000_000,
+			ThumbnailSizes: Sizes{128, 512},
+			MaxSize:        1 << 20,
+		},
+		Uploads: Uploads{
+			Dir:        os.TempDir(),
+			TTL:        24 * time.Hour,
+			MaxPerUser: 10,
+		},
+		Rating: Rating{
+			Min:  1,
+			Max:  10,
+			Step: 0.5,
+		},
+		Storage: Storage{
+			DataDir: "data",
+			Blob:    "local",
+			BlobDir: "img",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file,
+// the environment and the command line flags, each of them overrides
+// the previous ones. Flags are parsed first to find the file, but applied
+// last. Errors of the flags parsing are returned as is, e.g. flag.ErrHelp.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, Options, error) {
+	var opts Options
+	parsed := Default()
+	flags := parsed.flagSet(name)
+	flags.StringVar(&opts.File, "config", "", "path to the YAML configuration file, also set by "+EnvPrefix+"CONFIG")
+	flags.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, opts, err
+	}
+	if flags.NArg() > 0 {
+		return nil, opts, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if len(opts.File) == 0 {
+		opts.File, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+
+	config := Default()
+	if len(opts.File) > 0 {
+		err = config.readFile(opts.File)
+		if err != nil {
+			return nil, opts, err
+		}
+	}
+	layer := config.flagSet(name)
+	err = config.applyEnv(layer, lookupEnv)
+	if err != nil {
+		return nil, opts, err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if replacement, ok := deprecatedFlags[f.Name]; ok {
+			log.Printf("flag -%v is deprecated, use -%v", f.Name, replacement)
+		}
+		if layer.Lookup(f.Name) != nil && err == nil {
+			err = layer.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, opts, err
+	}
+	if config.TLS.MTLS {
+		config.TLS.Enabled = true
+	}
+	return config, opts, nil
+}
+
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config file %v: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv(flags *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	var err error
+	set := func(f *flag.Flag, name string) {
+		value, ok := lookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value of %v: %w", name, setErr)
+		}
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, ok := deprecatedFlags[f.Name]; ok {
+			return
+		}
+		if name, ok := legacyEnv[f.Name]; ok {
+			set(f, name)
+		}
+		set(f, EnvName(f.Name))
+	})
+	return err
+}
+
+// EnvName returns the environment variable of the flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate returns all problems of the configuration joined.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch c.Server.Type {
+	case "grpc", "combined":
+	case "rest":
+		check(len(c.Server.Endpoint) > 0, "gRPC endpoint is required by REST server")
+	default:
+		errs = append(errs, fmt.Errorf("unknown server type %q", c.Server.Type))
+	}
+	check(c.Server.Port >= 0 && c.Server.Port <= 65535, "server port %d is out of range", c.Server.Port)
+	check(c.Server.DrainTimeout >= 0, "drain timeout must not be negative")
+	check(c.Server.HealthInterval > 0, "health interval must be positive")
+
+	if c.TLS.Enabled {
+		files := []string{c.TLS.CertFile, c.TLS.KeyFile}
+		if c.TLS.MTLS {
+			files = append(files, c.TLS.ClientCAFile)
+		}
+		for _, file := range files {
+			_, err := os.Stat(file)
+			check(err == nil, "TLS file is not readable: %v", err)
+		}
+	}
+
+	check(len(c.Auth.JWTSecret) > 0, "JWT secret is empty")
+	check(c.Server.Dev || c.Auth.JWTSecret != DefaultJWTSecret,
+		"default JWT secret is only allowed in development mode, set %v", EnvName("jwt-secret"))
+	check(c.Auth.TokenTTL > 0, "token TTL must be positive")
+	switch c.Auth.PasswordHash {
+	case "bcrypt":
+		check(c.Auth.BcryptCost >= bcrypt.MinCost && c.Auth.BcryptCost <= bcrypt.MaxCost,
+			"bcrypt cost must be in range [%d, %d]", bcrypt.MinCost, bcrypt.MaxCost)
+	case "argon2id":
+	default:
+		errs = append(errs, fmt.Errorf("unknown password hashing algorithm %q", c.Auth.PasswordHash))
+	}
+	check(c.Auth.PasswordPolicy.MinLength > 0, "minimal password length must be positive")
+	for _, class := range c.Auth.PasswordPolicy.Require {
+		switch class {
+		case "upper", "lower", "digit", "symbol":
+		default:
+			errs = append(errs, fmt.Errorf("unknown password character class %q", class))
+		}
+	}
+	if len(c.Auth.PasswordPolicy.DenylistFile) > 0 {
+		_, err := os.Stat(c.Auth.PasswordPolicy.DenylistFile)
+		check(err == nil, "password denylist file is not readable: %v", err)
+	}
+	check(len(c.Audit.Log) > 0, "audit log path is empty")
+
+	check(len(c.Images.Formats) > 0, "no image formats are allowed")
+	check(c.Images.MaxWidth > 0 && c.Images.MaxHeight > 0, "maximal image dimensions must be positive")
+	check(c.Images.MaxPixels > 0, "maximal image area must be positive")
+	check(c.Images.MaxSize >= 0 && c.Images.MaxPerLaptop >= 0 && c.Images.StorageQuota >= 0, "upload limits must not be negative")
+	for role, limits := range c.Images.RoleLimits {
+		check(limits.valid(), "upload limits of role %v must not be negative", role)
+	}
+	check(len(c.Uploads.Dir) > 0, "upload folder is empty")
+	check(c.Uploads.TTL > 0, "upload TTL must be positive")
+	check(c.Uploads.MaxPerUser >= 0, "maximal number of uploads per user must not be negative")
+
+	err := models.ValidateRatingScale(c.Rating.Min, c.Rating.Max, c.Rating.Step)
+	check(err == nil, "invalid rating scale: %v", err)
+
+	switch c.Storage.Blob {
+	case "local":
+		check(len(c.Storage.BlobDir) > 0, "blob folder is empty")
+	case "memory":
+	case "s3":
+		check(len(c.Storage.S3.Endpoint) > 0, "S3 endpoint is empty")
+		check(len(c.Storage.S3.Bucket) > 0, "S3 bucket is empty")
+	default:
+		errs = append(errs, fmt.Errorf("unknown blob store %q", c.Storage.Blob))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns the copy of the configuration with secrets replaced.
+func (c *Config) Redacted() *Config {
+	res := *c
+	redact := func(secret *string) {
+		if len(*secret) > 0 {
+			*secret = redacted
+		}
+	}
+	redact(&res.Auth.JWTSecret)
+	redact(&res.Storage.S3.AccessKey)
+	redact(&res.Storage.S3.SecretKey)
+	return &res
+}
+
+// Print writes the configuration with secrets redacted as YAML.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(c.Redacted())
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
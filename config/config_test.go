@@ -0,0 +1,165 @@
+package config_test
+
+import (
+	"bytes"
+	"main/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `
+server:
+  type: rest
+  port: 1000
+  endpoint: localhost:8080
+  drain-timeout: 5s
+images:
+  formats: [png]
+  thumbnail-sizes: [64]
+  role-limits:
+    vendor:
+      max-image-size: 2048
+      max-images-per-laptop: 3
+auth:
+  password-policy:
+    min-length: 8
+    require: [digit, symbol]
+`)
+	env := map[string]string{
+		"PCBOOK_CONFIG":          path,
+		"PCBOOK_PORT":            "2000",
+		"PCBOOK_HEALTH_INTERVAL": "1m",
+		"PCBOOK_IMAGE_FORMATS":   "jpeg,png",
+		"PCBOOK_DEV":             "true",
+	}
+	cfg, opts, err := config.Load("server", []string{"-port", "3000", "-thumbnail-sizes", "", "-password-min-length", "12"}, lookupEnv(env))
+	require.NoError(t, err)
+	require.Equal(t, path, opts.File)
+	require.False(t, opts.PrintConfig)
+
+	require.Equal(t, 3000, cfg.Server.Port)
+	require.Equal(t, "rest", cfg.Server.Type)
+	require.Equal(t, 5*time.Second, cfg.Server.DrainTimeout)
+	require.Equal(t, time.Minute, cfg.Server.HealthInterval)
+	require.Equal(t, config.List{"jpeg", "png"}, cfg.Images.Formats)
+	require.Empty(t, cfg.Images.ThumbnailSizes)
+	require.Equal(t, config.RoleLimits{"vendor": {MaxImageSize: 2048, MaxImagesPerLaptop: 3}}, cfg.Images.RoleLimits)
+	require.Equal(t, config.PasswordPolicy{MinLength: 12, Require: config.List{"digit", "symbol"}}, cfg.Auth.PasswordPolicy)
+	require.Equal(t, config.Default().Auth.JWTSecret, cfg.Auth.JWTSecret)
+	require.True(t, cfg.Server.Dev)
+	require.NoError(t, cfg.Validate())
+}
+
+func TestLoadCompatibility(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"S3_ACCESS_KEY":        "legacy-access",
+		"S3_SECRET_KEY":        "legacy-secret",
+		"PCBOOK_S3_SECRET_KEY": "secret",
+	}
+	cfg, _, err := config.Load("server", []string{"-tsl"}, lookupEnv(env))
+	require.NoError(t, err)
+	require.True(t, cfg.TLS.Enabled)
+	require.False(t, cfg.TLS.MTLS)
+	require.Equal(t, "legacy-access", cfg.Storage.S3.AccessKey)
+	require.Equal(t, "secret", cfg.Storage.S3.SecretKey)
+
+	cfg, _, err = config.Load("server", []string{"-mtls"}, lookupEnv(nil))
+	require.NoError(t, err)
+	require.True(t, cfg.TLS.Enabled)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, "server:\n  prot: 8080\n")
+	_, _, err := config.Load("server", []string{"-config", path}, lookupEnv(nil))
+	require.ErrorContains(t, err, "prot")
+
+	_, _, err = config.Load("server", nil, lookupEnv(map[string]string{"PCBOOK_PORT": "http"}))
+	require.ErrorContains(t, err, "PCBOOK_PORT")
+
+	_, _, err = config.Load("server", []string{"-role-upload-limits", "vendor=1/2"}, lookupEnv(nil))
+	require.Error(t, err)
+
+	_, _, err = config.Load("server", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, lookupEnv(nil))
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	// the default JWT secret is only allowed in development mode
+	cfg := config.Default()
+	require.ErrorContains(t, cfg.Validate(), "development mode")
+	cfg.Server.Dev = true
+	require.NoError(t, cfg.Validate())
+	cfg.Server.Dev = false
+	cfg.Auth.JWTSecret = "production-secret"
+	require.NoError(t, cfg.Validate())
+
+	cfg = config.Default()
+	cfg.Rating.Max = 1e6
+	cfg.Rating.Step = 1e-4
+	require.ErrorContains(t, cfg.Validate(), "scores")
+
+	cfg = config.Default()
+	cfg.Server.Type = "rest"
+	cfg.Auth.JWTSecret = ""
+	cfg.Auth.BcryptCost = 100
+	cfg.Auth.PasswordPolicy.Require = config.List{"emoji"}
+	cfg.Auth.PasswordPolicy.DenylistFile = filepath.Join(t.TempDir(), "missing.txt")
+	cfg.Rating.Min = 10
+	cfg.Storage.Blob = "s3"
+	cfg.Images.RoleLimits = config.RoleLimits{"vendor": {StorageQuota: -1}}
+	cfg.TLS.Enabled = true
+	cfg.TLS.CertFile = filepath.Join(t.TempDir(), "missing.pem")
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, problem := range []string{"endpoint", "JWT secret", "bcrypt cost", "character class", "denylist file", "rating scale", "S3 bucket", "role vendor", "TLS file"} {
+		require.ErrorContains(t, err, problem)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "jwt-secret-value"
+	cfg.Storage.S3.SecretKey = "s3-secret-value"
+	cfg.Images.RoleLimits = config.RoleLimits{"vendor": {MaxImageSize: 2048}}
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+	require.NotContains(t, out.String(), "jwt-secret-value")
+	require.NotContains(t, out.String(), "s3-secret-value")
+	require.Contains(t, out.String(), "REDACTED")
+	require.Equal(t, "jwt-secret-value", cfg.Auth.JWTSecret)
+
+	// printed configuration is a valid configuration file
+	path := writeConfigFile(t, out.String())
+	loaded, _, err := config.Load("server", []string{"-config", path}, lookupEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, cfg.Redacted(), loaded)
+}
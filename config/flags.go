@@ -0,0 +1,169 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// List is a list of strings, comma separated in flags.
+type List []string
+
+func (l *List) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(value string) error {
+	*l = nil
+	if len(value) == 0 {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		*l = append(*l, strings.TrimSpace(item))
+	}
+	return nil
+}
+
+// Sizes is a list of sizes in pixels, comma separated in flags.
+type Sizes []uint32
+
+func (s *Sizes) String() string {
+	items := make([]string, len(*s))
+	for i, size := range *s {
+		items[i] = strconv.FormatUint(uint64(size), 10)
+	}
+	return strings.Join(items, ",")
+}
+
+func (s *Sizes) Set(value string) error {
+	*s = nil
+	if len(value) == 0 {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		size, err := strconv.ParseUint(strings.TrimSpace(item), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid size %q: %w", item, err)
+		}
+		*s = append(*s, uint32(size))
+	}
+	return nil
+}
+
+// UploadLimits overrides the upload limits for a role, 0 disables a limit.
+type UploadLimits struct {
+	MaxImageSize       int64 `yaml:"max-image-size"`
+	MaxImagesPerLaptop int   `yaml:"max-images-per-laptop"`
+	StorageQuota       int64 `yaml:"storage-quota"`
+}
+
+func (l UploadLimits) valid() bool {
+	return l.MaxImageSize >= 0 && l.MaxImagesPerLaptop >= 0 && l.StorageQuota >= 0
+}
+
+// RoleLimits are upload limits by roles, in flags they are comma separated
+// in the form role=size/images/quota.
+type RoleLimits map[string]UploadLimits
+
+func (r *RoleLimits) String() string {
+	roles := make([]string, 0, len(*r))
+	for role := range *r {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	items := make([]string, len(roles))
+	for i, role := range roles {
+		limits := (*r)[role]
+		items[i] = fmt.Sprintf("%v=%d/%d/%d", role, limits.MaxImageSize, limits.MaxImagesPerLaptop, limits.StorageQuota)
+	}
+	return strings.Join(items, ",")
+}
+
+func (r *RoleLimits) Set(value string) error {
+	*r = make(RoleLimits)
+	if len(value) == 0 {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		role, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		parts := strings.Split(value, "/")
+		if !ok || len(role) == 0 || len(parts) != 3 {
+			return fmt.Errorf("invalid upload limits %q", item)
+		}
+		var limits UploadLimits
+		var err error
+		limits.MaxImageSize, err = strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid image size of role %v: %w", role, err)
+		}
+		limits.MaxImagesPerLaptop, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid image count of role %v: %w", role, err)
+		}
+		limits.StorageQuota, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid storage quota of role %v: %w", role, err)
+		}
+		(*r)[role] = limits
+	}
+	return nil
+}
+
+// flagSet binds flags to the fields of the configuration, current values
+// are the defaults of the flags.
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flags.StringVar(&c.Server.Type, "type", c.Server.Type, "type of server: grpc/rest/combined")
+	flags.IntVar(&c.Server.Port, "port", c.Server.Port, "the server port")
+	flags.StringVar(&c.Server.Endpoint, "endpoint", c.Server.Endpoint, "gRPC endpoint")
+	flags.BoolVar(&c.Server.Reflection, "reflection", c.Server.Reflection, "enable gRPC server reflection")
+	flags.BoolVar(&c.Server.Dev, "dev", c.Server.Dev, "development mode, allows the default JWT secret")
+	flags.DurationVar(&c.Server.DrainTimeout, "drain-timeout", c.Server.DrainTimeout, "time to finish in-flight requests on shutdown before they are cancelled")
+	flags.DurationVar(&c.Server.HealthInterval, "health-interval", c.Server.HealthInterval, "interval between readiness checks of storages")
+
+	flags.BoolVar(&c.TLS.Enabled, "tls", c.TLS.Enabled, "enable SSL/TLS")
+	flags.BoolVar(&c.TLS.Enabled, "tsl", c.TLS.Enabled, "deprecated, use -tls")
+	flags.BoolVar(&c.TLS.MTLS, "mtls", c.TLS.MTLS, "require and verify client certificates, implies TLS")
+	flags.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "path to the server certificate")
+	flags.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "path to the private key of the server certificate")
+	flags.StringVar(&c.TLS.ClientCAFile, "tls-client-ca", c.TLS.ClientCAFile, "path to the CA certificate to verify client certificates")
+
+	flags.StringVar(&c.Auth.JWTSecret, "jwt-secret", c.Auth.JWTSecret, "secret key to sign JWT")
+	flags.DurationVar(&c.Auth.TokenTTL, "token-ttl", c.Auth.TokenTTL, "lifetime of JWT")
+	flags.StringVar(&c.Auth.PasswordHash, "password-hash", c.Auth.PasswordHash, "password hashing algorithm: bcrypt/argon2id")
+	flags.IntVar(&c.Auth.BcryptCost, "bcrypt-cost", c.Auth.BcryptCost, "bcrypt cost of password hashes")
+	flags.IntVar(&c.Auth.PasswordPolicy.MinLength, "password-min-length", c.Auth.PasswordPolicy.MinLength, "minimal length of user passwords")
+	flags.Var(&c.Auth.PasswordPolicy.Require, "password-require", "comma separated character classes required in user passwords: upper/lower/digit/symbol")
+	flags.StringVar(&c.Auth.PasswordPolicy.DenylistFile, "password-denylist", c.Auth.PasswordPolicy.DenylistFile, "path to the file of rejected passwords, one per line")
+	flags.StringVar(&c.Audit.Log, "audit-log", c.Audit.Log, "path to the audit log file")
+
+	flags.Var(&c.Images.Formats, "image-formats", "comma separated list of allowed image formats")
+	flags.IntVar(&c.Images.MaxWidth, "image-max-width", c.Images.MaxWidth, "maximal width of uploaded images in pixels")
+	flags.IntVar(&c.Images.MaxHeight, "image-max-height", c.Images.MaxHeight, "maximal height of uploaded images in pixels")
+	flags.Int64Var(&c.Images.MaxPixels, "image-max-pixels", c.Images.MaxPixels, "maximal area of uploaded images in pixels, checked before they are decoded")
+	flags.Var(&c.Images.ThumbnailSizes, "thumbnail-sizes", "comma separated list of thumbnail sizes in pixels, empty disables thumbnails")
+	flags.Int64Var(&c.Images.MaxSize, "max-image-size", c.Images.MaxSize, "maximal size of uploaded image in bytes, 0 disables the limit")
+	flags.IntVar(&c.Images.MaxPerLaptop, "max-images-per-laptop", c.Images.MaxPerLaptop, "maximal number of images of a laptop, 0 disables the limit")
+	flags.Int64Var(&c.Images.StorageQuota, "storage-quota", c.Images.StorageQuota, "total size of images uploaded by a user in bytes, 0 disables the limit")
+	flags.Var(&c.Images.RoleLimits, "role-upload-limits", "comma separated upload limits of roles as role=size/images/quota, e.g. vendor=2097152/20/104857600")
+	flags.StringVar(&c.Uploads.Dir, "upload-dir", c.Uploads.Dir, "folder for data of uploads in progress")
+	flags.DurationVar(&c.Uploads.TTL, "upload-ttl", c.Uploads.TTL, "time after which abandoned uploads are removed")
+	flags.IntVar(&c.Uploads.MaxPerUser, "max-uploads-per-user", c.Uploads.MaxPerUser, "maximal number of uploads in progress of a user, 0 disables the limit")
+
+	flags.Float64Var(&c.Rating.Min, "rating-min", c.Rating.Min, "minimal score of laptops")
+	flags.Float64Var(&c.Rating.Max, "rating-max", c.Rating.Max, "maximal score of laptops")
+	flags.Float64Var(&c.Rating.Step, "rating-step", c.Rating.Step, "step between allowed scores of laptops")
+
+	flags.StringVar(&c.Storage.DataDir, "data-dir", c.Storage.DataDir, "folder for ratings, users, tenants, API keys and image metadata, empty keeps them in memory only")
+	flags.StringVar(&c.Storage.Blob, "blob-store", c.Storage.Blob, "storage of image files: local/memory/s3")
+	flags.StringVar(&c.Storage.BlobDir, "blob-dir", c.Storage.BlobDir, "folder for image files of the local storage")
+	flags.StringVar(&c.Storage.S3.Endpoint, "s3-endpoint", c.Storage.S3.Endpoint, "URL of S3-compatible storage, e.g. http://localhost:9000")
+	flags.StringVar(&c.Storage.S3.Region, "s3-region", c.Storage.S3.Region, "region of S3 storage")
+	flags.StringVar(&c.Storage.S3.Bucket, "s3-bucket", c.Storage.S3.Bucket, "bucket of S3 storage for image files")
+	flags.StringVar(&c.Storage.S3.AccessKey, "s3-access-key", c.Storage.S3.AccessKey, "access key of S3 storage, also set by S3_ACCESS_KEY")
+	flags.StringVar(&c.Storage.S3.SecretKey, "s3-secret-key", c.Storage.S3.SecretKey, "secret key of S3 storage, also set by S3_SECRET_KEY")
+	return flags
+}
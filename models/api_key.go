@@ -0,0 +1,80 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const apiKeyPrefix = "pcb_"
+
+type APIKey struct {
+	ID        string
+	Name      string
+	HashedKey string
+	Role      string
+	TenantID  string
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// NewAPIKey creates API key and returns it with the raw key.
+// Only hash of the raw key is kept in APIKey.
+func NewAPIKey(name string, role string, tenantID string, scopes []string, ttl time.Duration) (*APIKey, string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate id: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("cannot generate key: %w", err)
+	}
+	rawKey := apiKeyPrefix + hex.EncodeToString(secret)
+	now := time.Now()
+	key := &APIKey{
+		ID:        id.String(),
+		Name:      name,
+		HashedKey: HashAPIKey(rawKey),
+		Role:      role,
+		TenantID:  tenantID,
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+	return key, rawKey, nil
+}
+
+func HashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsActive reports whether key is not revoked and not expired.
+func (k *APIKey) IsActive(now time.Time) bool {
+	if k.Revoked {
+		return false
+	}
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+func (k *APIKey) Clone() *APIKey {
+	return &APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		HashedKey: k.HashedKey,
+		Role:      k.Role,
+		TenantID:  k.TenantID,
+		Scopes:    append([]string(nil), k.Scopes...),
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+		Revoked:   k.Revoked,
+	}
+}
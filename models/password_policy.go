@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrWeakPassword = errors.New("password does not satisfy the policy")
+)
+
+// commonPasswords are rejected by every policy.
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1",
+	"qwerty", "qwerty123", "qwertyuiop", "abc123", "111111", "123123",
+	"letmein", "welcome", "admin", "admin123", "iloveyou", "monkey",
+	"dragon", "football", "baseball", "sunshine", "princess", "passw0rd",
+}
+
+// PasswordRules configure the password policy.
+type PasswordRules struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Denylist is rejected in addition to the common passwords, case insensitive.
+	Denylist []string
+}
+
+type PasswordPolicy struct {
+	PasswordRules
+	denylist map[string]bool
+}
+
+func NewPasswordPolicy(rules PasswordRules) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		PasswordRules: rules,
+		denylist:      make(map[string]bool, len(commonPasswords)+len(rules.Denylist)),
+	}
+	for _, password := range commonPasswords {
+		policy.denylist[password] = true
+	}
+	for _, password := range rules.Denylist {
+		policy.denylist[strings.ToLower(password)] = true
+	}
+	return policy
+}
+
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return NewPasswordPolicy(PasswordRules{
+		MinLength:    10,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	})
+}
+
+// Validate returns error wrapping ErrWeakPassword with the
+// first rule the password breaks.
+func (p *PasswordPolicy) Validate(username string, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters long", ErrWeakPassword, p.MinLength)
+	}
+	lower := strings.ToLower(password)
+	if p.denylist[lower] {
+		return fmt.Errorf("%w: password is too common", ErrWeakPassword)
+	}
+	if len(username) > 0 && strings.Contains(lower, strings.ToLower(username)) {
+		return fmt.Errorf("%w: must not contain username", ErrWeakPassword)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		return fmt.Errorf("%w: must contain an upper case letter", ErrWeakPassword)
+	}
+	if p.RequireLower && !hasLower {
+		return fmt.Errorf("%w: must contain a lower case letter", ErrWeakPassword)
+	}
+	if p.RequireDigit && !hasDigit {
+		return fmt.Errorf("%w: must contain a digit", ErrWeakPassword)
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return fmt.Errorf("%w: must contain a symbol", ErrWeakPassword)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+const ratingScaleEpsilon = 1e-9
+
+// MaxRatingScaleScores limits the number of allowed scores, statistics
+// keep a histogram bucket for each of them.
+const MaxRatingScaleScores = 1000
+
+// ValidateRatingScale checks that values from min to max inclusive which
+// differ from min by a multiple of step form a usable set of scores.
+func ValidateRatingScale(min float64, max float64, step float64) error {
+	for _, value := range []float64{min, max, step} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("rating scale must be finite")
+		}
+	}
+	if min >= max {
+		return fmt.Errorf("minimal score %v must be less than maximal %v", min, max)
+	}
+	if step <= 0 {
+		return fmt.Errorf("score step %v must be positive", step)
+	}
+	if (max-min)/step+1 > MaxRatingScaleScores {
+		return fmt.Errorf("score range [%v, %v] with step %v has more than %v scores", min, max, step, MaxRatingScaleScores)
+	}
+	if !OnRatingStep(min, step, max) {
+		return fmt.Errorf("score range [%v, %v] is not divisible by step %v", min, max, step)
+	}
+	return nil
+}
+
+// OnRatingStep reports whether the score differs from min by a multiple of step.
+func OnRatingStep(min float64, step float64, score float64) bool {
+	steps := (score - min) / step
+	return math.Abs(steps-math.Round(steps)) < ratingScaleEpsilon
+}
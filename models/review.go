@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxReviewTitleLength = 120
+	maxReviewTextLength  = 4000
+)
+
+var (
+	ErrInvalidReview = errors.New("invalid review")
+)
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+	ReviewFlagged  ReviewStatus = "flagged"
+)
+
+// Review is the score of the user with the text. New reviews
+// are pending and become visible only after approval.
+type Review struct {
+	ID             string
+	TenantID       string
+	LaptopID       string
+	Author         string
+	Score          float64
+	Title          string
+	Text           string
+	Status         ReviewStatus
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	ModeratedBy    string
+	ModerationNote string
+}
+
+func NewReview(tenantID string, laptopID string, author string, score float64, title string, text string) (*Review, error) {
+	title = strings.TrimSpace(title)
+	text = strings.TrimSpace(text)
+	if len(title) == 0 || len(text) == 0 {
+		return nil, fmt.Errorf("%w: title and text are required", ErrInvalidReview)
+	}
+	if utf8.RuneCountInString(title) > maxReviewTitleLength {
+		return nil, fmt.Errorf("%w: title is longer than %d characters", ErrInvalidReview, maxReviewTitleLength)
+	}
+	if utf8.RuneCountInString(text) > maxReviewTextLength {
+		return nil, fmt.Errorf("%w: text is longer than %d characters", ErrInvalidReview, maxReviewTextLength)
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate id: %w", err)
+	}
+	now := time.Now()
+	return &Review{
+		ID:        id.String(),
+		TenantID:  tenantID,
+		LaptopID:  laptopID,
+		Author:    author,
+		Score:     score,
+		Title:     title,
+		Text:      text,
+		Status:    ReviewPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+func (r *Review) Clone() *Review {
+	other := *r
+	return &other
+}
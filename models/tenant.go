@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DefaultTenantID is the tenant of users which were not assigned
+// to any other tenant and of anonymous requests.
+const DefaultTenantID = "default"
+
+type Tenant struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+func (t *Tenant) Clone() *Tenant {
+	return &Tenant{
+		ID:        t.ID,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+	}
+}
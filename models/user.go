@@ -1,7 +1,6 @@
 package models
 
 import (
-	"fmt"
 	"log"
 
 	"golang.org/x/crypto/bcrypt"
@@ -11,10 +10,11 @@ type User struct {
 	UserName       string
 	HashedPassword string
 	Role           string
+	TenantID       string
 }
 
 func (u *User) IsPasswordCorrect(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(password))
+	err := VerifyPassword(u.HashedPassword, password)
 	log.Println("models.user.IsPasswordCorrect", err)
 	return err == nil
 }
@@ -24,17 +24,23 @@ func (u *User) Clone() *User {
 		UserName:       u.UserName,
 		HashedPassword: u.HashedPassword,
 		Role:           u.Role,
+		TenantID:       u.TenantID,
 	}
 }
 
 func NewUser(username string, password string, role string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return NewUserWithHasher(username, password, role, NewBcryptHasher(bcrypt.DefaultCost))
+}
+
+func NewUserWithHasher(username string, password string, role string, hasher PasswordHasher) (*User, error) {
+	hashedPassword, err := hasher.Hash(password)
 	if err != nil {
-		return nil, fmt.Errorf("cannot hash password: %w", err)
+		return nil, err
 	}
 	return &User{
 		UserName:       username,
-		HashedPassword: string(hashedPassword),
+		HashedPassword: hashedPassword,
 		Role:           role,
+		TenantID:       DefaultTenantID,
 	}, nil
 }
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"main/models"
+)
+
+type APIKeyStorager interface {
+	Save(key *models.APIKey) error
+	Get(id string) (*models.APIKey, error)
+	GetByHash(hashedKey string) (*models.APIKey, error)
+	List() ([]*models.APIKey, error)
+	Revoke(id string) error
+}
+
+// apiKeyPermissions returns permissions of the key role
+// limited by the key scopes.
+func apiKeyPermissions(key *models.APIKey) []string {
+	permissions := RolePermissions(key.Role)
+	if len(key.Scopes) == 0 {
+		return permissions
+	}
+	scoped := make([]string, 0, len(key.Scopes))
+	for _, permission := range permissions {
+		if hasPermission(key.Scopes, permission) {
+			scoped = append(scoped, permission)
+		}
+	}
+	return scoped
+}
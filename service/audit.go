@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"main/audit"
+	"main/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type AuditLogger interface {
+	Log(record audit.Record) error
+	Query(filter audit.Filter) ([]*audit.Record, error)
+}
+
+// AuditInterceptor records calls of the audited methods. It must be
+// chained after AuthInterceptor to know the principal, calls denied by
+// AuthInterceptor are recorded by it.
+type AuditInterceptor struct {
+	auditLogger    AuditLogger
+	auditedMethods map[string]bool
+}
+
+func NewAuditInterceptor(auditLogger AuditLogger, auditedMethods map[string]bool) *AuditInterceptor {
+	return &AuditInterceptor{
+		auditLogger:    auditLogger,
+		auditedMethods: auditedMethods,
+	}
+}
+
+func (i *AuditInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp any, err error) {
+		resp, err = handler(ctx, req)
+		if i.auditedMethods[info.FullMethod] {
+			i.record(ctx, info.FullMethod, resourceOf(req, resp), err)
+		}
+		return resp, err
+	}
+}
+
+func (i *AuditInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !i.auditedMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		stream := &resourceStream{ServerStream: ss}
+		err := handler(srv, stream)
+		i.record(ss.Context(), info.FullMethod, stream.resource, err)
+		return err
+	}
+}
+
+// resourceStream keeps the resource of the first received message,
+// streaming calls change a single laptop named by it.
+type resourceStream struct {
+	grpc.ServerStream
+	resource string
+	received bool
+}
+
+func (s *resourceStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && !s.received {
+		s.received = true
+		s.resource = streamResourceOf(m)
+	}
+	return err
+}
+
+func (i *AuditInterceptor) record(ctx context.Context, method string, resource string, err error) {
+	state, _ := status.FromError(err)
+	record := audit.Record{
+		Event:    audit.EventCall,
+		Username: usernameFromContext(ctx),
+		TenantID: tenantFromContext(ctx),
+		Method:   method,
+		Resource: resource,
+		Code:     state.Code().String(),
+		Message:  state.Message(),
+	}
+	logAudit(i.auditLogger, record)
+}
+
+// resourceOf returns id of the created or changed entity.
+func resourceOf(req any, resp any) string {
+	type identified interface {
+		GetId() string
+	}
+	if r, ok := resp.(identified); ok && len(r.GetId()) > 0 {
+		return r.GetId()
+	}
+	if r, ok := req.(identified); ok {
+		return r.GetId()
+	}
+	return ""
+}
+
+// streamResourceOf returns id of the laptop changed by the stream. Streams
+// continuing an upload only name the upload, so its id is returned.
+func streamResourceOf(req any) string {
+	type laptopScoped interface {
+		GetLaptopId() string
+	}
+	switch r := req.(type) {
+	case laptopScoped:
+		return r.GetLaptopId()
+	case *pb.UploadImageRequest:
+		if info := r.GetInfo(); info != nil {
+			return info.GetLaptopId()
+		}
+		return r.GetChunk().GetUploadId()
+	}
+	return ""
+}
+
+func logAudit(auditLogger AuditLogger, record audit.Record) {
+	if auditLogger == nil {
+		return
+	}
+	err := auditLogger.Log(record)
+	if err != nil {
+		log.Printf("cannot write audit record: %v", err)
+	}
+}
+
+type AuditServer struct {
+	auditLogger AuditLogger
+	pb.UnimplementedAuditServiceServer
+}
+
+func NewAuditServer(auditLogger AuditLogger) *AuditServer {
+	return &AuditServer{
+		auditLogger: auditLogger,
+	}
+}
+
+func (as *AuditServer) QueryAuditLog(ctx context.Context, req *pb.QueryAuditLogRequest) (*pb.QueryAuditLogResponse, error) {
+	filter := audit.Filter{
+		Username: req.GetUsername(),
+		Method:   req.GetMethod(),
+	}
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+	// Only tenant administrators see records of all tenants.
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || !principal.HasPermission(PermissionTenantAdmin) {
+		filter.TenantID = tenantFromContext(ctx)
+	}
+
+	records, err := as.auditLogger.Query(filter)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, audit.ErrTampered) {
+			code = codes.DataLoss
+		}
+		return nil, status.Errorf(code, "cannot query audit log: %v", err)
+	}
+	if limit := int(req.GetLimit()); limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
+	resp := &pb.QueryAuditLogResponse{
+		Records: make([]*pb.AuditRecord, 0, len(records)),
+	}
+	for _, record := range records {
+		resp.Records = append(resp.Records, &pb.AuditRecord{
+			Seq:      record.Seq,
+			Time:     timestamppb.New(record.Time),
+			Event:    record.Event,
+			Username: record.Username,
+			TenantId: record.TenantID,
+			Method:   record.Method,
+			Resource: record.Resource,
+			Code:     record.Code,
+			Message:  record.Message,
+			PrevHash: record.PrevHash,
+			Hash:     record.Hash,
+		})
+	}
+	return resp, nil
+}
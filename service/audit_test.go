@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"main/audit"
+	"main/models"
+	"main/pb"
+	"main/service"
+	"main/storage"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// recvStream receives the message once.
+type recvStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	msg proto.Message
+}
+
+func (s *recvStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *recvStream) RecvMsg(m any) error {
+	proto.Merge(m.(proto.Message), s.msg)
+	return nil
+}
+
+func TestAuditInterceptorStreamResource(t *testing.T) {
+	t.Parallel()
+
+	auditLogger, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	testCases := []struct {
+		method   string
+		msg      proto.Message
+		newMsg   func() proto.Message
+		resource string
+	}{
+		{
+			method:   "/pc.LaptopService/RateLaptop",
+			msg:      &pb.RateLaptopRequest{LaptopId: "laptop1", Score: 8},
+			newMsg:   func() proto.Message { return &pb.RateLaptopRequest{} },
+			resource: "laptop1",
+		},
+		{
+			method: "/pc.LaptopService/UploadImage",
+			msg: &pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{
+				Info: &pb.ImageInfo{LaptopId: "laptop2", ImageType: ".png"},
+			}},
+			newMsg:   func() proto.Message { return &pb.UploadImageRequest{} },
+			resource: "laptop2",
+		},
+	}
+
+	auditedMethods := make(map[string]bool)
+	for _, tc := range testCases {
+		auditedMethods[tc.method] = true
+	}
+	interceptor := service.NewAuditInterceptor(auditLogger, auditedMethods)
+	ctx := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "user1", TenantID: "tenant1"})
+	for _, tc := range testCases {
+		stream := &recvStream{ctx: ctx, msg: tc.msg}
+		handler := func(srv any, ss grpc.ServerStream) error {
+			// only the first message names the resource
+			require.NoError(t, ss.RecvMsg(tc.newMsg()))
+			stream.msg = tc.newMsg()
+			return ss.RecvMsg(tc.newMsg())
+		}
+		err := interceptor.Stream()(nil, stream, &grpc.StreamServerInfo{FullMethod: tc.method}, handler)
+		require.NoError(t, err)
+
+		records, err := auditLogger.Query(audit.Filter{Method: tc.method})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, tc.resource, records[0].Resource)
+		require.Equal(t, "tenant1", records[0].TenantID)
+	}
+}
+
+func TestAuthServerAuditsFailedLoginTenant(t *testing.T) {
+	t.Parallel()
+
+	auditLogger, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	userStorage := storage.NewUserStorage()
+	user, err := models.NewUserWithHasher("alice", "Correct9Horse", "user", models.NewBcryptHasher(bcrypt.MinCost))
+	require.NoError(t, err)
+	user.TenantID = "tenant1"
+	require.NoError(t, userStorage.Save(user))
+	server := service.NewAuthServer(
+		userStorage,
+		storage.NewAPIKeyStorage(),
+		service.NewJWTManager("secret", time.Minute),
+		auditLogger,
+		models.NewBcryptHasher(bcrypt.MinCost),
+		models.DefaultPasswordPolicy(),
+	)
+
+	_, err = server.Login(context.Background(), &pb.LogRequest{Username: "alice", Password: "wrong"})
+	require.Error(t, err)
+	_, err = server.Login(context.Background(), &pb.LogRequest{Username: "unknown", Password: "wrong"})
+	require.Error(t, err)
+
+	records, err := auditLogger.Query(audit.Filter{Method: "/pc.AuthService/Login"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, audit.EventLoginFailed, records[0].Event)
+	require.Equal(t, "tenant1", records[0].TenantID)
+	require.Equal(t, models.DefaultTenantID, records[1].TenantID)
+}
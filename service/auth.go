@@ -2,23 +2,46 @@ package service
 
 import (
 	"context"
+	"errors"
 	"log"
+	"main/audit"
+	"main/models"
 	"main/pb"
+	"main/storage"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+const registeredUserRole = "user"
+
 type AuthServer struct {
-	userStorage UserStorager
-	jwtManager  *JWTManager
+	userStorage    UserStorager
+	apiKeyStorage  APIKeyStorager
+	jwtManager     *JWTManager
+	auditLogger    AuditLogger
+	passwordHasher models.PasswordHasher
+	passwordPolicy *models.PasswordPolicy
 	pb.UnimplementedAuthServiceServer
 }
 
-func NewAuthServer(userStorage UserStorager, jwtManager *JWTManager) *AuthServer {
+// NewAuthServer creates auth server. auditLogger is optional.
+func NewAuthServer(
+	userStorage UserStorager,
+	apiKeyStorage APIKeyStorager,
+	jwtManager *JWTManager,
+	auditLogger AuditLogger,
+	passwordHasher models.PasswordHasher,
+	passwordPolicy *models.PasswordPolicy,
+) *AuthServer {
 	return &AuthServer{
-		userStorage: userStorage,
-		jwtManager:  jwtManager,
+		userStorage:    userStorage,
+		apiKeyStorage:  apiKeyStorage,
+		jwtManager:     jwtManager,
+		auditLogger:    auditLogger,
+		passwordHasher: passwordHasher,
+		passwordPolicy: passwordPolicy,
 	}
 }
 
@@ -28,17 +51,212 @@ func (au *AuthServer) Login(ctx context.Context, req *pb.LogRequest) (*pb.LogRes
 		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
 	}
 	if user == nil || !user.IsPasswordCorrect(req.GetPassword()) {
-		log.Printf("invalid creds for %v \n", user)
+		log.Printf("invalid creds for %v\n", req.GetUsername())
+		// unknown users belong to the default tenant, as anonymous requests do
+		tenantID := models.DefaultTenantID
+		if user != nil && len(user.TenantID) > 0 {
+			tenantID = user.TenantID
+		}
+		logAudit(au.auditLogger, audit.Record{
+			Event:    audit.EventLoginFailed,
+			Username: req.GetUsername(),
+			TenantID: tenantID,
+			Method:   "/pc.AuthService/Login",
+			Code:     codes.NotFound.String(),
+			Message:  "invalid creds",
+		})
 		return nil, status.Errorf(codes.NotFound, "invalid creds")
 	}
+	au.rehashPassword(user, req.GetPassword())
 
 	token, err := au.jwtManager.Generate(user)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "cannot generate jwt")
 	}
+	logAudit(au.auditLogger, audit.Record{
+		Event:    audit.EventLogin,
+		Username: user.UserName,
+		TenantID: user.TenantID,
+		Method:   "/pc.AuthService/Login",
+		Code:     codes.OK.String(),
+	})
 
 	resp := &pb.LogResponse{
 		Token: token,
 	}
 	return resp, nil
 }
+
+func (au *AuthServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if len(req.GetUsername()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "username is empty")
+	}
+	err := au.passwordPolicy.Validate(req.GetUsername(), req.GetPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid password: %v", err)
+	}
+
+	user, err := models.NewUserWithHasher(req.GetUsername(), req.GetPassword(), registeredUserRole, au.passwordHasher)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create user: %v", err)
+	}
+	err = au.userStorage.Save(user)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			code = codes.AlreadyExists
+		}
+		return nil, status.Errorf(code, "cannot save user: %v", err)
+	}
+	log.Printf("user %s registered", user.UserName)
+	return &pb.RegisterResponse{}, nil
+}
+
+func (au *AuthServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	user, err := au.userStorage.Get(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+	if user == nil || !user.IsPasswordCorrect(req.GetOldPassword()) {
+		return nil, status.Errorf(codes.NotFound, "invalid creds")
+	}
+	err = au.passwordPolicy.Validate(user.UserName, req.GetNewPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid password: %v", err)
+	}
+
+	hashedPassword, err := au.passwordHasher.Hash(req.GetNewPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot hash password: %v", err)
+	}
+	user.HashedPassword = hashedPassword
+	err = au.userStorage.Update(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot update user: %v", err)
+	}
+	log.Printf("password of user %s changed", user.UserName)
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+// rehashPassword replaces the stored hash if it was made with outdated
+// parameters. Login does not fail if rehash fails.
+func (au *AuthServer) rehashPassword(user *models.User, password string) {
+	if !au.passwordHasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	hashedPassword, err := au.passwordHasher.Hash(password)
+	if err != nil {
+		log.Printf("cannot rehash password of user %s: %v", user.UserName, err)
+		return
+	}
+	user.HashedPassword = hashedPassword
+	err = au.userStorage.Update(user)
+	if err != nil {
+		log.Printf("cannot update password of user %s: %v", user.UserName, err)
+		return
+	}
+	log.Printf("password of user %s rehashed", user.UserName)
+}
+
+func (au *AuthServer) CreateApiKey(ctx context.Context, req *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "api key name is empty")
+	}
+	if len(req.GetRole()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "api key role is empty")
+	}
+	permissions := RolePermissions(req.GetRole())
+	if len(permissions) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role %v", req.GetRole())
+	}
+	for _, scope := range req.GetScopes() {
+		if !hasPermission(permissions, scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "scope %v is not allowed for role %v", scope, req.GetRole())
+		}
+	}
+	// the key cannot grant more than the caller holds
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "api key cannot be created anonymously")
+	}
+	for _, permission := range append(permissions, req.GetScopes()...) {
+		if !principal.HasPermission(permission) {
+			return nil, status.Errorf(codes.PermissionDenied, "permission %v of role %v is not granted to %v", permission, req.GetRole(), principal.Username)
+		}
+	}
+	ttl := req.GetTtl().AsDuration()
+	if ttl < 0 {
+		return nil, status.Error(codes.InvalidArgument, "api key ttl is negative")
+	}
+
+	key, rawKey, err := models.NewAPIKey(req.GetName(), req.GetRole(), tenantFromContext(ctx), req.GetScopes(), ttl)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate api key: %v", err)
+	}
+	err = au.apiKeyStorage.Save(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save api key: %v", err)
+	}
+	log.Printf("api key %s created with role %s", key.ID, key.Role)
+
+	resp := &pb.CreateApiKeyResponse{
+		ApiKey: apiKeyToPb(key),
+		Key:    rawKey,
+	}
+	return resp, nil
+}
+
+func (au *AuthServer) ListApiKeys(ctx context.Context, req *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	keys, err := au.apiKeyStorage.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list api keys: %v", err)
+	}
+
+	tenantID := tenantFromContext(ctx)
+	resp := &pb.ListApiKeysResponse{
+		ApiKeys: make([]*pb.ApiKey, 0, len(keys)),
+	}
+	for _, key := range keys {
+		if key.TenantID != tenantID {
+			continue
+		}
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyToPb(key))
+	}
+	return resp, nil
+}
+
+func (au *AuthServer) RevokeApiKey(ctx context.Context, req *pb.RevokeApiKeyRequest) (*pb.RevokeApiKeyResponse, error) {
+	key, err := au.apiKeyStorage.Get(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find api key with id %v: %v", req.GetId(), err)
+	}
+	if key == nil || key.TenantID != tenantFromContext(ctx) {
+		return nil, status.Errorf(codes.NotFound, "api key with id %v is not found", req.GetId())
+	}
+
+	err = au.apiKeyStorage.Revoke(req.GetId())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot revoke api key with id %v: %v", req.GetId(), err)
+	}
+	log.Printf("api key %s revoked", req.GetId())
+	return &pb.RevokeApiKeyResponse{}, nil
+}
+
+func apiKeyToPb(key *models.APIKey) *pb.ApiKey {
+	res := &pb.ApiKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Role:      key.Role,
+		Scopes:    key.Scopes,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+		Revoked:   key.Revoked,
+	}
+	if !key.ExpiresAt.IsZero() {
+		res.ExpiresAt = timestamppb.New(key.ExpiresAt)
+	}
+	return res
+}
@@ -2,7 +2,11 @@ package service
 
 import (
 	"context"
+	"crypto/x509"
 	"log"
+	"main/audit"
+	"main/models"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -10,15 +14,33 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const apiKeyHeader = "x-api-key"
+
 type AuthInterceptor struct {
-	jwtManager      *JWTManager
-	accessibleRoles map[string][]string
+	jwtManager          *JWTManager
+	apiKeyStorage       APIKeyStorager
+	certMapper          *CertIdentityMapper
+	requiredPermissions map[string]string
+	auditLogger         AuditLogger
 }
 
-func NewAuthInterceptor(jwtWanager *JWTManager, roles map[string][]string) *AuthInterceptor {
+// NewAuthInterceptor creates interceptor. permissions maps full method
+// name to the permission required to call it. apiKeyStorage and certMapper
+// are optional: if nil, API keys or client certificates are not accepted.
+// auditLogger is optional: if nil, denied calls are not recorded.
+func NewAuthInterceptor(
+	jwtWanager *JWTManager,
+	apiKeyStorage APIKeyStorager,
+	certMapper *CertIdentityMapper,
+	permissions map[string]string,
+	auditLogger AuditLogger,
+) *AuthInterceptor {
 	return &AuthInterceptor{
-		jwtManager:      jwtWanager,
-		accessibleRoles: roles,
+		jwtManager:          jwtWanager,
+		apiKeyStorage:       apiKeyStorage,
+		certMapper:          certMapper,
+		requiredPermissions: permissions,
+		auditLogger:         auditLogger,
 	}
 }
 
@@ -30,7 +52,7 @@ func (i *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 	) (resp any, err error) {
 		log.Println("--> unary intercepter: ", info.FullMethod)
 
-		err = i.authorize(ctx, info.FullMethod)
+		ctx, err = i.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
@@ -46,40 +68,137 @@ func (i *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		handler grpc.StreamHandler,
 	) error {
 		log.Println("--> stream intercepter: ", info.FullMethod)
-		err := i.authorize(ss.Context(), info.FullMethod)
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
-		return handler(srv, ss)
+		return handler(srv, &serverStreamWithContext{ServerStream: ss, ctx: ctx})
 	}
 }
 
-func (i *AuthInterceptor) authorize(ctx context.Context, method string) error {
-	accessibleRoles, ok := i.accessibleRoles[method]
-	// If not roles for this method, then it method available
+// authorize checks access to the method and returns context
+// with the authenticated principal.
+func (i *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	permission, ok := i.requiredPermissions[method]
+	// If not permission for this method, then it method available
 	// for all users.
 	if !ok {
-		return nil
+		principal, err := i.authenticate(ctx)
+		if err == nil {
+			ctx = ContextWithPrincipal(ctx, principal)
+		}
+		return ctx, nil
+	}
+
+	principal, err := i.authenticate(ctx)
+	if err != nil {
+		i.recordDenied(method, nil, err)
+		return nil, err
+	}
+
+	if !principal.HasPermission(permission) {
+		err = status.Error(codes.PermissionDenied, "no permissions to access this RPC")
+		i.recordDenied(method, principal, err)
+		return nil, err
+	}
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// recordDenied writes the rejected call to the audit log, principal is nil
+// if the caller is not authenticated. Calls of unauthenticated callers
+// belong to the default tenant, as anonymous requests do.
+func (i *AuthInterceptor) recordDenied(method string, principal *Principal, err error) {
+	state, _ := status.FromError(err)
+	record := audit.Record{
+		Event:    audit.EventDenied,
+		TenantID: models.DefaultTenantID,
+		Method:   method,
+		Code:     state.Code().String(),
+		Message:  state.Message(),
+	}
+	if principal != nil {
+		record.Username = principal.Username
+		if len(principal.TenantID) > 0 {
+			record.TenantID = principal.TenantID
+		}
 	}
+	logAudit(i.auditLogger, record)
+}
+
+func (i *AuthInterceptor) authenticate(ctx context.Context) (*Principal, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Error(codes.Unauthenticated, "no metadata")
+		return nil, status.Error(codes.Unauthenticated, "no metadata")
+	}
+
+	if keys := md[apiKeyHeader]; len(keys) > 0 {
+		return i.authenticateAPIKey(keys[0])
 	}
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return status.Error(codes.Unauthenticated, "auth token in not present")
+		// Peer authenticated by mTLS does not need token.
+		if cert := peerCertificate(ctx); cert != nil && i.certMapper != nil {
+			return i.authenticateCert(cert)
+		}
+		return nil, status.Error(codes.Unauthenticated, "auth token in not present")
 	}
+
 	token := values[0]
 	claim, err := i.jwtManager.Verify(token)
 	if err != nil {
-		return status.Error(codes.Unauthenticated, "token is invalid")
+		return nil, status.Error(codes.Unauthenticated, "token is invalid")
 	}
+	return &Principal{
+		Username:    claim.Username,
+		Role:        claim.Role,
+		TenantID:    claim.TenantID,
+		Permissions: claim.Permissions,
+	}, nil
+}
 
-	for _, role := range accessibleRoles {
-		if role == claim.Role {
-			return nil
-		}
+func (i *AuthInterceptor) authenticateAPIKey(rawKey string) (*Principal, error) {
+	if i.apiKeyStorage == nil {
+		return nil, status.Error(codes.Unauthenticated, "api keys are not supported")
+	}
+	key, err := i.apiKeyStorage.GetByHash(models.HashAPIKey(rawKey))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find api key: %v", err)
+	}
+	if key == nil || !key.IsActive(time.Now()) {
+		return nil, status.Error(codes.Unauthenticated, "api key is invalid")
+	}
+	return &Principal{
+		Username:    "apikey:" + key.Name,
+		Role:        key.Role,
+		TenantID:    key.TenantID,
+		Permissions: apiKeyPermissions(key),
+	}, nil
+}
+
+func (i *AuthInterceptor) authenticateCert(cert *x509.Certificate) (*Principal, error) {
+	user, err := i.certMapper.Map(cert)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot map certificate to user: %v", err)
+	}
+	if user == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "no user for certificate %v", cert.Subject.CommonName)
 	}
-	return status.Error(codes.PermissionDenied, "no permissions to access this RPC")
+	return &Principal{
+		Username:    user.UserName,
+		Role:        user.Role,
+		TenantID:    user.TenantID,
+		Permissions: RolePermissions(user.Role),
+	}, nil
+}
+
+// serverStreamWithContext overrides context of the stream
+// to pass principal to the handler.
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStreamWithContext) Context() context.Context {
+	return s.ctx
 }
@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"main/audit"
+	"main/models"
+	"main/service"
+	"main/storage"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthInterceptorAPIKey(t *testing.T) {
+	t.Parallel()
+
+	const method = "/pc.LaptopService/CreateLaptop"
+	apiKeyStorage := storage.NewAPIKeyStorage()
+	newKey := func(role string, scopes []string, ttl time.Duration) string {
+		key, rawKey, err := models.NewAPIKey("ingestion", role, models.DefaultTenantID, scopes, ttl)
+		require.NoError(t, err)
+		require.NoError(t, apiKeyStorage.Save(key))
+		return rawKey
+	}
+
+	validKey := newKey("admin", nil, time.Hour)
+	scopedKey := newKey("admin", []string{service.PermissionLaptopCreate}, 0)
+	otherScopeKey := newKey("admin", []string{service.PermissionImageUpload}, 0)
+	userKey := newKey("user", nil, 0)
+	expiredKey := newKey("admin", nil, time.Nanosecond)
+	revokedKey, rawRevokedKey, err := models.NewAPIKey("old", "admin", models.DefaultTenantID, nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, apiKeyStorage.Save(revokedKey))
+	require.NoError(t, apiKeyStorage.Revoke(revokedKey.ID))
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	interceptor := service.NewAuthInterceptor(jwtManager, apiKeyStorage, nil, map[string]string{
+		method: service.PermissionLaptopCreate,
+	}, nil)
+
+	testCases := []struct {
+		name         string
+		key          string
+		expectedCode codes.Code
+	}{
+		{name: "valid key", key: validKey, expectedCode: codes.OK},
+		{name: "scoped key", key: scopedKey, expectedCode: codes.OK},
+		{name: "out of scope", key: otherScopeKey, expectedCode: codes.PermissionDenied},
+		{name: "wrong role", key: userKey, expectedCode: codes.PermissionDenied},
+		{name: "expired key", key: expiredKey, expectedCode: codes.Unauthenticated},
+		{name: "revoked key", key: rawRevokedKey, expectedCode: codes.Unauthenticated},
+		{name: "unknown key", key: "pcb_unknown", expectedCode: codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", tc.key))
+			handler := func(ctx context.Context, req any) (any, error) {
+				principal, ok := service.PrincipalFromContext(ctx)
+				require.True(t, ok)
+				require.Equal(t, "apikey:ingestion", principal.Username)
+				require.Equal(t, "admin", principal.Role)
+				return "ok", nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: method}
+
+			resp, err := interceptor.Unary()(ctx, nil, info, handler)
+			if tc.expectedCode == codes.OK {
+				require.NoError(t, err)
+				require.Equal(t, "ok", resp)
+			} else {
+				require.Error(t, err)
+				state, ok := status.FromError(err)
+				require.True(t, ok)
+				require.Equal(t, tc.expectedCode, state.Code())
+			}
+		})
+	}
+}
+
+func TestAuthInterceptorAuditsDeniedCalls(t *testing.T) {
+	t.Parallel()
+
+	const method = "/pc.TenantService/CreateTenant"
+	auditLogger, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, nil, map[string]string{
+		method: service.PermissionTenantAdmin,
+	}, auditLogger)
+	user, err := models.NewUser("alice", "Correct9Horse", "user")
+	require.NoError(t, err)
+	user.TenantID = "tenant1"
+	token, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	_, err = interceptor.Unary()(metadata.NewIncomingContext(context.Background(), metadata.MD{}), nil, info, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+	_, err = interceptor.Unary()(ctx, nil, info, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	records, err := auditLogger.Query(audit.Filter{Method: method})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, audit.EventDenied, records[0].Event)
+	require.Empty(t, records[0].Username)
+	require.Equal(t, models.DefaultTenantID, records[0].TenantID)
+	require.Equal(t, codes.Unauthenticated.String(), records[0].Code)
+	require.Equal(t, audit.EventDenied, records[1].Event)
+	require.Equal(t, "alice", records[1].Username)
+	require.Equal(t, "tenant1", records[1].TenantID)
+	require.Equal(t, codes.PermissionDenied.String(), records[1].Code)
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"main/models"
+	"main/pb"
+	"main/service"
+	"main/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthServerRegister(t *testing.T) {
+	t.Parallel()
+
+	userStorage := storage.NewUserStorage()
+	server := newTestAuthServer(userStorage, models.NewBcryptHasher(bcrypt.MinCost))
+
+	testCases := []struct {
+		name         string
+		username     string
+		password     string
+		expectedCode codes.Code
+	}{
+		{name: "valid", username: "alice", password: "Correct9Horse", expectedCode: codes.OK},
+		{name: "already exists", username: "alice", password: "Correct9Horse", expectedCode: codes.AlreadyExists},
+		{name: "short", username: "bob", password: "Sh0rt", expectedCode: codes.InvalidArgument},
+		{name: "no digit", username: "bob", password: "CorrectHorse", expectedCode: codes.InvalidArgument},
+		{name: "no upper", username: "bob", password: "correct9horse", expectedCode: codes.InvalidArgument},
+		{name: "common", username: "bob", password: "1234567890", expectedCode: codes.InvalidArgument},
+		{name: "contains username", username: "bob", password: "Bobby12345", expectedCode: codes.InvalidArgument},
+		{name: "empty username", username: "", password: "Correct9Horse", expectedCode: codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		_, err := server.Register(context.Background(), &pb.RegisterRequest{
+			Username: tc.username,
+			Password: tc.password,
+		})
+		require.Equal(t, tc.expectedCode, status.Code(err), tc.name)
+	}
+
+	user, err := userStorage.Get("alice")
+	require.NoError(t, err)
+	require.Equal(t, "user", user.Role)
+	require.True(t, user.IsPasswordCorrect("Correct9Horse"))
+}
+
+func TestAuthServerChangePassword(t *testing.T) {
+	t.Parallel()
+
+	userStorage := storage.NewUserStorage()
+	hasher := models.NewBcryptHasher(bcrypt.MinCost)
+	user, err := models.NewUserWithHasher("alice", "Correct9Horse", "user", hasher)
+	require.NoError(t, err)
+	require.NoError(t, userStorage.Save(user))
+	server := newTestAuthServer(userStorage, hasher)
+
+	_, err = server.ChangePassword(context.Background(), &pb.ChangePasswordRequest{
+		Username:    "alice",
+		OldPassword: "wrong",
+		NewPassword: "Battery7Staple",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.ChangePassword(context.Background(), &pb.ChangePasswordRequest{
+		Username:    "alice",
+		OldPassword: "Correct9Horse",
+		NewPassword: "weak",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.ChangePassword(context.Background(), &pb.ChangePasswordRequest{
+		Username:    "alice",
+		OldPassword: "Correct9Horse",
+		NewPassword: "Battery7Staple",
+	})
+	require.NoError(t, err)
+
+	user, err = userStorage.Get("alice")
+	require.NoError(t, err)
+	require.False(t, user.IsPasswordCorrect("Correct9Horse"))
+	require.True(t, user.IsPasswordCorrect("Battery7Staple"))
+}
+
+func TestAuthServerRehashOnLogin(t *testing.T) {
+	t.Parallel()
+
+	userStorage := storage.NewUserStorage()
+	user, err := models.NewUserWithHasher("alice", "Correct9Horse", "user", models.NewBcryptHasher(bcrypt.MinCost))
+	require.NoError(t, err)
+	require.NoError(t, userStorage.Save(user))
+
+	hasher := models.NewArgon2idHasher()
+	hasher.Memory = 1024
+	server := newTestAuthServer(userStorage, hasher)
+
+	_, err = server.Login(context.Background(), &pb.LogRequest{Username: "alice", Password: "Correct9Horse"})
+	require.NoError(t, err)
+
+	user, err = userStorage.Get("alice")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(user.HashedPassword, "$argon2id$"))
+	require.False(t, hasher.NeedsRehash(user.HashedPassword))
+
+	_, err = server.Login(context.Background(), &pb.LogRequest{Username: "alice", Password: "Correct9Horse"})
+	require.NoError(t, err)
+	_, err = server.Login(context.Background(), &pb.LogRequest{Username: "alice", Password: "wrong"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func newTestAuthServer(userStorage service.UserStorager, hasher models.PasswordHasher) *service.AuthServer {
+	return service.NewAuthServer(
+		userStorage,
+		storage.NewAPIKeyStorage(),
+		service.NewJWTManager("secret", time.Minute),
+		nil,
+		hasher,
+		models.DefaultPasswordPolicy(),
+	)
+}
+
+func TestAuthServerCreateApiKeyPermissions(t *testing.T) {
+	t.Parallel()
+
+	server := newTestAuthServer(storage.NewUserStorage(), models.NewBcryptHasher(bcrypt.MinCost))
+	admin := service.ContextWithPrincipal(context.Background(), &service.Principal{
+		Username:    "admin",
+		Role:        "admin",
+		TenantID:    "default",
+		Permissions: service.RolePermissions("admin"),
+	})
+
+	_, err := server.CreateApiKey(admin, &pb.CreateApiKeyRequest{Name: "escalate", Role: "superadmin"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = server.CreateApiKey(admin, &pb.CreateApiKeyRequest{Name: "tenant", Role: "superadmin", Scopes: []string{service.PermissionTenantAdmin}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = server.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{Name: "anonymous", Role: "user"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	resp, err := server.CreateApiKey(admin, &pb.CreateApiKeyRequest{Name: "ci", Role: "vendor", Scopes: []string{service.PermissionImageUpload}})
+	require.NoError(t, err)
+	require.Equal(t, "vendor", resp.GetApiKey().GetRole())
+}
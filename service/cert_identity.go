@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"main/models"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// CertIdentityMapper maps verified client certificate to the user.
+// Subject common name is checked first, then DNS and email SANs.
+type CertIdentityMapper struct {
+	userStorage UserStorager
+}
+
+func NewCertIdentityMapper(userStorage UserStorager) *CertIdentityMapper {
+	return &CertIdentityMapper{
+		userStorage: userStorage,
+	}
+}
+
+func (m *CertIdentityMapper) Map(cert *x509.Certificate) (*models.User, error) {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses))
+	if len(cert.Subject.CommonName) > 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+
+	for _, name := range names {
+		user, err := m.userStorage.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find user %v: %w", name, err)
+		}
+		if user != nil {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// peerCertificate returns leaf certificate of the client if
+// it was verified during TLS handshake.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ReadinessCheck returns an error if the dependency of the service,
+// e.g. its storage, cannot serve requests.
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthMonitor sets serving status of services in the health server by their
+// readiness checks. The overall status, the empty service name, is SERVING
+// only when all services are.
+type HealthMonitor struct {
+	server *health.Server
+	checks map[string][]ReadinessCheck
+	mu     sync.Mutex
+	// failing keeps the last error of not serving services to log changes only
+	failing map[string]string
+}
+
+// NewHealthMonitor marks all services as NOT_SERVING until they are checked.
+func NewHealthMonitor(server *health.Server, checks map[string][]ReadinessCheck) *HealthMonitor {
+	for name := range checks {
+		server.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &HealthMonitor{
+		server:  server,
+		checks:  checks,
+		failing: make(map[string]string),
+	}
+}
+
+// Update runs the checks once and sets statuses of the services.
+func (m *HealthMonitor) Update(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.checks))
+	for name := range m.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	overall := healthpb.HealthCheckResponse_SERVING
+	for _, name := range names {
+		serving := healthpb.HealthCheckResponse_SERVING
+		for _, check := range m.checks[name] {
+			err := check(ctx)
+			if err == nil {
+				continue
+			}
+			serving = healthpb.HealthCheckResponse_NOT_SERVING
+			if m.failing[name] != err.Error() {
+				log.Printf("service %v is not serving: %v", name, err)
+				m.failing[name] = err.Error()
+			}
+			break
+		}
+		if serving == healthpb.HealthCheckResponse_SERVING {
+			if _, ok := m.failing[name]; ok {
+				log.Printf("service %v is serving again", name)
+				delete(m.failing, name)
+			}
+		} else {
+			overall = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		m.server.SetServingStatus(name, serving)
+	}
+	m.server.SetServingStatus("", overall)
+}
+
+// Run updates statuses every interval until the context is done.
+// Every round of checks is limited by the interval.
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		m.Update(checkCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"main/service"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var storageErr error
+	storageCheck := func(ctx context.Context) error {
+		return storageErr
+	}
+	server := health.NewServer()
+	monitor := service.NewHealthMonitor(server, map[string][]service.ReadinessCheck{
+		"pc.LaptopService": {storageCheck},
+		"pc.AuditService":  {},
+	})
+	requireServingStatus := func(name string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		resp, err := server.Check(ctx, &healthpb.HealthCheckRequest{Service: name})
+		require.NoError(t, err)
+		require.Equal(t, expected, resp.GetStatus(), name)
+	}
+
+	// services are not serving until they are checked
+	requireServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireServingStatus("pc.AuditService", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	monitor.Update(ctx)
+	requireServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	requireServingStatus("pc.LaptopService", healthpb.HealthCheckResponse_SERVING)
+
+	storageErr = errors.New("disk is full")
+	monitor.Update(ctx)
+	requireServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireServingStatus("pc.LaptopService", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireServingStatus("pc.AuditService", healthpb.HealthCheckResponse_SERVING)
+
+	storageErr = nil
+	monitor.Update(ctx)
+	requireServingStatus("", healthpb.HealthCheckResponse_SERVING)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+	ImageFormatWebP = "webp"
+)
+
+var imageFormatExtensions = map[string]string{
+	ImageFormatJPEG: ".jpg",
+	ImageFormatPNG:  ".png",
+	ImageFormatWebP: ".webp",
+}
+
+// ImageValidator checks uploaded images by their content,
+// so the type supplied by client is never trusted.
+type ImageValidator struct {
+	formats   map[string]bool
+	maxWidth  int
+	maxHeight int
+	// maxPixels limits the area of the image, so a small file cannot
+	// be decoded into a huge bitmap
+	maxPixels int64
+}
+
+func NewImageValidator(formats []string, maxWidth int, maxHeight int, maxPixels int64) (*ImageValidator, error) {
+	validator := &ImageValidator{
+		formats:   make(map[string]bool, len(formats)),
+		maxWidth:  maxWidth,
+		maxHeight: maxHeight,
+		maxPixels: maxPixels,
+	}
+	for _, format := range formats {
+		format = normalizeImageFormat(format)
+		if _, ok := imageFormatExtensions[format]; !ok {
+			return nil, fmt.Errorf("unsupported image format %q", format)
+		}
+		validator.formats[format] = true
+	}
+	return validator, nil
+}
+
+func NewDefaultImageValidator() *ImageValidator {
+	validator, _ := NewImageValidator([]string{ImageFormatJPEG, ImageFormatPNG, ImageFormatWebP}, 8192, 8192, 40_000_000)
+	return validator
+}
+
+// Validate sniffs format of the image, checks that it is allowed, matches
+// the type supplied by client and is decoded without errors. Dimensions
+// are checked by the header before the image is decoded, so large images
+// are rejected without allocating their bitmaps. It returns extension the
+// image should be stored with.
+func (v *ImageValidator) Validate(imageData io.Reader, imageType string) (string, error) {
+	reader := bufio.NewReader(imageData)
+	// the longest signature is the WebP one
+	header, err := reader.Peek(12)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("cannot read image: %w", err)
+	}
+	format := sniffImageFormat(header)
+	if len(format) == 0 {
+		return "", fmt.Errorf("unknown image format")
+	}
+	if !v.formats[format] {
+		return "", fmt.Errorf("image format %v is not allowed", format)
+	}
+	if len(imageType) > 0 && normalizeImageFormat(imageType) != format {
+		return "", fmt.Errorf("image type %q does not match content %v", imageType, format)
+	}
+
+	// the data read for the config is read again by the full decode
+	var configData bytes.Buffer
+	config, decodedFormat, err := image.DecodeConfig(io.TeeReader(reader, &configData))
+	if err != nil {
+		return "", fmt.Errorf("cannot decode image config: %w", err)
+	}
+	if decodedFormat != format {
+		return "", fmt.Errorf("image is decoded as %v, expected %v", decodedFormat, format)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return "", fmt.Errorf("image has invalid size %dx%d", config.Width, config.Height)
+	}
+	if config.Width > v.maxWidth || config.Height > v.maxHeight {
+		return "", fmt.Errorf("image is too large: %dx%d > %dx%d", config.Width, config.Height, v.maxWidth, v.maxHeight)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > v.maxPixels {
+		return "", fmt.Errorf("image has too many pixels: %d > %d", pixels, v.maxPixels)
+	}
+	_, _, err = image.Decode(io.MultiReader(&configData, reader))
+	if err != nil {
+		return "", fmt.Errorf("cannot decode image: %w", err)
+	}
+	return imageFormatExtensions[format], nil
+}
+
+func sniffImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return ImageFormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ImageFormatPNG
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return ImageFormatWebP
+	default:
+		return ""
+	}
+}
+
+// normalizeImageFormat maps extension or MIME type of the image to its format.
+func normalizeImageFormat(imageType string) string {
+	format := strings.ToLower(strings.TrimSpace(imageType))
+	format = strings.TrimPrefix(format, "image/")
+	format = strings.TrimPrefix(format, ".")
+	if format == "jpg" {
+		return ImageFormatJPEG
+	}
+	return format
+}
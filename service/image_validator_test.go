@@ -0,0 +1,83 @@
+package service_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"main/service"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageValidator(t *testing.T) {
+	t.Parallel()
+
+	jpegData, err := os.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+	webpData, err := os.ReadFile("../tmp/gopher.webp")
+	require.NoError(t, err)
+	pngData := encodeTestPNG(t, 16, 8)
+	largePNGData := encodeTestPNG(t, 200, 8)
+
+	validator, err := service.NewImageValidator([]string{"jpg", "png", "webp"}, 128, 128, 128*128)
+	require.NoError(t, err)
+	pngOnly, err := service.NewImageValidator([]string{"png"}, 32, 32, 32*32)
+	require.NoError(t, err)
+	largeValidator, err := service.NewImageValidator([]string{"jpeg"}, 4096, 4096, 4096*4096)
+	require.NoError(t, err)
+	smallArea, err := service.NewImageValidator([]string{"png"}, 128, 128, 100)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		validator   *service.ImageValidator
+		data        []byte
+		imageType   string
+		expectedExt string
+	}{
+		{name: "png", validator: validator, data: pngData, imageType: ".png", expectedExt: ".png"},
+		{name: "png without type", validator: validator, data: pngData, expectedExt: ".png"},
+		{name: "png mime type", validator: validator, data: pngData, imageType: "image/png", expectedExt: ".png"},
+		{name: "jpeg", validator: largeValidator, data: jpegData, imageType: ".jpeg", expectedExt: ".jpg"},
+		{name: "webp", validator: validator, data: webpData, imageType: ".webp", expectedExt: ".webp"},
+		{name: "type mismatch", validator: validator, data: pngData, imageType: ".jpg"},
+		{name: "path in type", validator: validator, data: pngData, imageType: "../x"},
+		{name: "not allowed", validator: pngOnly, data: webpData},
+		{name: "too large", validator: validator, data: largePNGData},
+		{name: "not an image", validator: validator, data: []byte("hello, world")},
+		{name: "too many pixels", validator: smallArea, data: pngData},
+		{name: "truncated header", validator: validator, data: pngData[:20]},
+		// the header is valid, but the pixel data is cut off
+		{name: "truncated", validator: validator, data: pngData[:len(pngData)-20]},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ext, err := tc.validator.Validate(bytes.NewReader(tc.data), tc.imageType)
+			if len(tc.expectedExt) == 0 {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedExt, ext)
+		})
+	}
+
+	_, err = service.NewImageValidator([]string{"gif"}, 32, 32, 32*32)
+	require.Error(t, err)
+}
+
+func encodeTestPNG(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 16), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
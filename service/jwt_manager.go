@@ -15,8 +15,10 @@ type JWTManager struct {
 
 type UserClaims struct {
 	jwt.StandardClaims
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username    string   `json:"username"`
+	Role        string   `json:"role"`
+	TenantID    string   `json:"tenant_id"`
+	Permissions []string `json:"permissions"`
 }
 
 func NewJWTManager(secretKey string, ttl time.Duration) *JWTManager {
@@ -31,8 +33,10 @@ func (j *JWTManager) Generate(user *models.User) (string, error) {
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(j.TTL).Unix(),
 		},
-		Username: user.UserName,
-		Role:     user.Role,
+		Username:    user.UserName,
+		Role:        user.Role,
+		TenantID:    user.TenantID,
+		Permissions: RolePermissions(user.Role),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(j.secretKey))
@@ -3,11 +3,16 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"log"
+	"main/blob"
 	"main/pb"
 	"main/storage"
+	"os"
+	"sort"
 
 	"time"
 
@@ -15,39 +20,131 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
-	maxImageSize = 1 << 20
+	downloadChunkSize = 64 << 10
 )
 
 type LaptopStorager interface {
-	Save(laptop *pb.Laptop) error
-	Get(id string) (*pb.Laptop, error)
-	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	Save(tenantID string, laptop *pb.Laptop) error
+	Update(tenantID string, laptop *pb.Laptop) error
+	Get(tenantID string, id string) (*pb.Laptop, error)
+	Delete(tenantID string, id string) error
+	Search(ctx context.Context, tenantID string, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
 }
 
 type ImageStorager interface {
 	Save(
+		ctx context.Context,
+		tenantID string,
 		laptopID string,
 		imageType string,
-		imageData bytes.Buffer,
+		uploadedBy string,
+		imageData io.Reader,
+		size int64,
+		digest string,
 	) (string, error)
+	Get(tenantID string, imageID string) (*storage.ImageInfo, error)
+	List(tenantID string, laptopID string) ([]*storage.ImageInfo, error)
+	SaveThumbnail(
+		ctx context.Context,
+		tenantID string,
+		imageID string,
+		size uint32,
+		imageType string,
+		imageData bytes.Buffer,
+	) error
+	Open(ctx context.Context, tenantID string, imageID string, size uint32) (io.ReadCloser, error)
+	Delete(ctx context.Context, tenantID string, imageID string) error
+	DeleteByLaptop(ctx context.Context, tenantID string, laptopID string) error
+	Primary(tenantID string, laptopID string) (string, error)
+	SetPrimary(tenantID string, laptopID string, imageID string) error
+	Reorder(tenantID string, laptopID string, imageIDs []string) error
+	Usage(tenantID string, uploadedBy string) (int, int64, error)
 }
 
 type RatingStorager interface {
-	Add(laptopId string, score float64) (*storage.Rating, error)
+	Rate(tenantID string, laptopId string, ratedBy string, score float64) (*storage.Rating, error)
+	Delete(tenantID string, laptopId string, ratedBy string) (*storage.Rating, error)
+	RateReview(tenantID string, laptopId string, author string, score float64) (*storage.Rating, error)
+	DeleteReview(tenantID string, laptopId string, author string) (*storage.Rating, error)
+	Scores(tenantID string, laptopId string) ([]*storage.Score, error)
+	Ratings(tenantID string) (map[string]*storage.Rating, error)
+	Events(tenantID string, laptopId string, before time.Time) ([]*storage.RatingEvent, error)
+	DeleteByLaptop(tenantID string, laptopId string) error
 }
 
 type LaptopServer struct {
-	LaptopStorage LaptopStorager
-	ImageStorage  ImageStorager
-	RatingStorage RatingStorager
+	LaptopStorage  LaptopStorager
+	ImageStorage   ImageStorager
+	RatingStorage  RatingStorager
+	ReviewStorage  ReviewStorager
+	UploadStorage  UploadStorager
+	ImageValidator *ImageValidator
+	Thumbnailer    *Thumbnailer
+	QuotaPolicy    *QuotaPolicy
+	RatingScale    *RatingScale
 	pb.UnimplementedLaptopServiceServer
 }
 
-func NewLaptopServer(laptopStorage LaptopStorager, imageStorage ImageStorager, ratingStorage RatingStorager) *LaptopServer {
-	return &LaptopServer{LaptopStorage: laptopStorage, ImageStorage: imageStorage, RatingStorage: ratingStorage}
+// LaptopServerOptions are the optional dependencies of the laptop server,
+// nil fields get the defaults.
+type LaptopServerOptions struct {
+	// ImageStorage is nil to keep images in memory.
+	ImageStorage ImageStorager
+	// RatingStorage is nil to keep ratings in memory.
+	RatingStorage RatingStorager
+	// ReviewStorage is nil to keep reviews in memory.
+	ReviewStorage ReviewStorager
+	// UploadStorage is nil to keep uploads in the temporary folder.
+	UploadStorage UploadStorager
+	// ImageValidator is nil to allow the default formats and dimensions.
+	ImageValidator *ImageValidator
+	// Thumbnailer is nil to skip thumbnails.
+	Thumbnailer *Thumbnailer
+	// QuotaPolicy is nil to limit only the size of an image.
+	QuotaPolicy *QuotaPolicy
+	// RatingScale is nil to use the default rating scale.
+	RatingScale *RatingScale
+}
+
+// NewLaptopServer creates laptop server with the optional dependencies.
+func NewLaptopServer(laptopStorage LaptopStorager, opts LaptopServerOptions) *LaptopServer {
+	server := &LaptopServer{
+		LaptopStorage:  laptopStorage,
+		ImageStorage:   opts.ImageStorage,
+		RatingStorage:  opts.RatingStorage,
+		ReviewStorage:  opts.ReviewStorage,
+		UploadStorage:  opts.UploadStorage,
+		ImageValidator: opts.ImageValidator,
+		Thumbnailer:    opts.Thumbnailer,
+		QuotaPolicy:    opts.QuotaPolicy,
+		RatingScale:    opts.RatingScale,
+	}
+	if server.ImageStorage == nil {
+		server.ImageStorage = storage.NewImageStorage(blob.NewMemoryStore())
+	}
+	if server.RatingStorage == nil {
+		server.RatingStorage = storage.NewRatingStorage()
+	}
+	if server.ReviewStorage == nil {
+		server.ReviewStorage = storage.NewReviewStorage()
+	}
+	if server.UploadStorage == nil {
+		server.UploadStorage = storage.NewUploadStorage(os.TempDir(), defaultUploadTTL, defaultMaxUploadsPerUser)
+	}
+	if server.ImageValidator == nil {
+		server.ImageValidator = NewDefaultImageValidator()
+	}
+	if server.QuotaPolicy == nil {
+		server.QuotaPolicy = DefaultQuotaPolicy()
+	}
+	if server.RatingScale == nil {
+		server.RatingScale = DefaultRatingScale()
+	}
+	return server
 }
 
 func (s *LaptopServer) CreateLaptop(
@@ -68,6 +165,9 @@ func (s *LaptopServer) CreateLaptop(
 		}
 		laptop.Id = id.String()
 	}
+	laptop.CreatedBy = usernameFromContext(ctx)
+	laptop.PrimaryImageId = ""
+	laptop.Images = nil
 	time.Sleep(time.Second)
 
 	if ctx.Err() == context.Canceled {
@@ -80,7 +180,7 @@ func (s *LaptopServer) CreateLaptop(
 		return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded")
 	}
 
-	err := s.LaptopStorage.Save(laptop)
+	err := s.LaptopStorage.Save(tenantFromContext(ctx), laptop)
 	if err != nil {
 		code := codes.Internal
 		if errors.Is(err, storage.ErrAlreadyExist) {
@@ -88,57 +188,194 @@ func (s *LaptopServer) CreateLaptop(
 		}
 		return nil, status.Error(code, "cennot save laptop")
 	}
-	log.Printf("create laptop with id: %s", laptop.Id)
+	log.Printf("create laptop with id: %s by %q", laptop.Id, laptop.CreatedBy)
 	return &pb.CreateLaptopResponse{Id: laptop.Id}, nil
 }
 
+func (s *LaptopServer) UpdateLaptop(
+	ctx context.Context,
+	req *pb.UpdateLaptopRequest,
+) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	log.Printf("receive an update of laptop with id: %s", laptop.GetId())
+
+	tenantID := tenantFromContext(ctx)
+	found, err := s.LaptopStorage.Get(tenantID, laptop.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop with id: %v: (%v)", laptop.GetId(), err)
+	}
+	if found == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptop.GetId())
+	}
+	err = checkOwnership(ctx, found)
+	if err != nil {
+		return nil, err
+	}
+
+	laptop.CreatedBy = found.GetCreatedBy()
+	laptop.UpdatedAt = timestamppb.Now()
+	laptop.PrimaryImageId = ""
+	laptop.Images = nil
+	err = s.LaptopStorage.Update(tenantID, laptop)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Error(code, "cannot update laptop")
+	}
+	log.Printf("update laptop with id: %s", laptop.GetId())
+	err = s.attachImages(tenantID, laptop)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateLaptopResponse{Laptop: laptop}, nil
+}
+
+func (s *LaptopServer) DeleteLaptop(
+	ctx context.Context,
+	req *pb.DeleteLaptopRequest,
+) (*pb.DeleteLaptopResponse, error) {
+	laptopId := req.GetLaptopId()
+	log.Printf("receive a delete of laptop with id: %s", laptopId)
+
+	tenantID := tenantFromContext(ctx)
+	_, err := s.getOwnedLaptop(ctx, laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	// images, reviews and ratings go first, so the laptop is kept if they
+	// cannot be deleted and the delete can be retried
+	err = s.ImageStorage.DeleteByLaptop(ctx, tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete images of laptop with id %v: %v", laptopId, err)
+	}
+	err = s.ReviewStorage.DeleteByLaptop(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete reviews of laptop with id %v: %v", laptopId, err)
+	}
+	err = s.RatingStorage.DeleteByLaptop(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete ratings of laptop with id %v: %v", laptopId, err)
+	}
+	err = s.LaptopStorage.Delete(tenantID, laptopId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot delete laptop with id %v: %v", laptopId, err)
+	}
+	log.Printf("delete laptop with id: %s", laptopId)
+	return &pb.DeleteLaptopResponse{}, nil
+}
+
 func (s *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream grpc.ServerStreamingServer[pb.SearchLaptopResponse],
 ) error {
 	filter := req.GetFilter()
 	log.Printf("recieve a seacrh laptop request with filter %v\n", filter)
-	err := s.LaptopStorage.Search(
+	tenantID := tenantFromContext(stream.Context())
+	ratings, err := s.RatingStorage.Ratings(tenantID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot get ratings: %v", err)
+	}
+
+	// sorted laptops are sent after all of them are found
+	sortByRating := filter.GetSortBy() == pb.SortBy_SORT_BY_RATING
+	var found []*pb.SearchLaptopResponse
+	err = s.LaptopStorage.Search(
 		stream.Context(),
+		tenantID,
 		filter,
 		func(laptop *pb.Laptop) error {
+			rating, ok := ratings[laptop.GetId()]
+			if !ok {
+				if filter.GetMinRating() > 0 {
+					return nil
+				}
+				rating = &storage.Rating{}
+			}
+			summary := s.RatingScale.ratingSummary(rating)
+			if summary.GetBayesianAverage() < filter.GetMinRating() {
+				return nil
+			}
+			err := s.attachImages(tenantID, laptop)
+			if err != nil {
+				return err
+			}
 			response := &pb.SearchLaptopResponse{
 				Laptop: laptop,
+				Rating: summary,
 			}
-			err := stream.Send(response)
-			if err != nil {
+			if sortByRating {
+				found = append(found, response)
 				return nil
 			}
-			log.Printf("send laptop with id %v", laptop.GetId())
+			sendSearchResponse(stream, response)
 			return nil
 		})
 	if err != nil {
 		return status.Error(codes.Internal, "internal error")
 	}
+
+	if sortByRating {
+		sort.SliceStable(found, func(i, j int) bool {
+			ri, rj := found[i].GetRating(), found[j].GetRating()
+			if ri.GetBayesianAverage() != rj.GetBayesianAverage() {
+				return ri.GetBayesianAverage() > rj.GetBayesianAverage()
+			}
+			return ri.GetRatedCount() > rj.GetRatedCount()
+		})
+		for _, response := range found {
+			sendSearchResponse(stream, response)
+		}
+	}
 	return nil
 }
 
+func sendSearchResponse(stream grpc.ServerStreamingServer[pb.SearchLaptopResponse], response *pb.SearchLaptopResponse) {
+	err := stream.Send(response)
+	if err != nil {
+		return
+	}
+	log.Printf("send laptop with id %v", response.GetLaptop().GetId())
+}
+
 func (s *LaptopServer) UploadImage(
 	stream grpc.ClientStreamingServer[pb.UploadImageRequest, pb.UploadImageResponse]) error {
 	req, err := stream.Recv()
 	if err != nil {
 		return status.Errorf(codes.Unknown, "cannon receive image info: %v", err)
 	}
-	laptopId := req.GetInfo().GetLaptopId()
-	imageType := req.GetInfo().GetImageType()
-	log.Printf("receive laptop with id: %v image type: %v", laptopId, imageType)
 
-	laptop, err := s.LaptopStorage.Get(laptopId)
-	if err != nil {
-		return status.Errorf(codes.Internal, "cannot get laptop with id %v: %v", laptopId, err)
-	}
-
-	if laptop == nil {
-		return status.Errorf(codes.Internal, "laptop with id: %v does not exists", laptopId)
+	// single stream upload creates implicit session, which cannot be resumed
+	var session *storage.UploadSession
+	implicit := req.GetInfo() != nil
+	if implicit {
+		session, err = s.createUploadSession(stream.Context(), req.GetInfo(), 0)
+		if err != nil {
+			return err
+		}
+		defer s.UploadStorage.Remove(session.ID)
+		// size is unknown yet, so only users who used the whole quota are rejected
+		err = s.checkStorageQuota(stream.Context(), session, 0)
+		if err != nil {
+			return err
+		}
+	} else {
+		session, err = s.getUploadSession(stream.Context(), req.GetChunk().GetUploadId())
+		if err != nil {
+			return err
+		}
+		err = s.appendChunk(stream.Context(), session, req)
+		if err != nil {
+			return err
+		}
 	}
-
-	imageData := bytes.Buffer{}
-	var imageSize int
+	log.Printf("receive image of laptop with id: %v to upload %v", session.LaptopID, session.ID)
 
 	for {
 		if stream.Context().Err() == context.Canceled {
@@ -159,25 +396,22 @@ func (s *LaptopServer) UploadImage(
 		if err != nil {
 			return status.Errorf(codes.Internal, "cannot receive data: %v", err)
 		}
-		chunk := req.GetChunkData()
-		size := len(chunk)
-		imageSize += size
-		if imageSize > maxImageSize {
-			return status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize)
-		}
-		_, err = imageData.Write(chunk)
+		err = s.appendChunk(stream.Context(), session, req)
 		if err != nil {
-			status.Errorf(codes.Internal, "cannot write data: %v", err)
+			return err
 		}
 	}
-	imageId, err := s.ImageStorage.Save(laptopId, imageType, imageData)
-	if err != nil {
-		return status.Errorf(codes.Internal, "cannot save image: %v", err)
-	}
 
-	resp := &pb.UploadImageResponse{
-		Id:       imageId,
-		ByteSize: uint32(imageSize),
+	session, err = s.UploadStorage.Get(session.ID)
+	if err != nil || session == nil {
+		return status.Errorf(codes.Internal, "cannot get upload session: %v", err)
+	}
+	if session.ByteSize > 0 && session.Offset < session.ByteSize {
+		return status.Errorf(codes.FailedPrecondition, "upload is incomplete: %d of %d bytes", session.Offset, session.ByteSize)
+	}
+	resp, err := s.finishUpload(stream.Context(), session)
+	if err != nil {
+		return err
 	}
 	err = stream.SendAndClose(resp)
 	if err != nil {
@@ -187,7 +421,270 @@ func (s *LaptopServer) UploadImage(
 	return nil
 }
 
+// saveThumbnails does not fail the upload, since the original
+// image is already saved and can be downloaded. Thumbnails of
+// already stored content are not generated again.
+func (s *LaptopServer) saveThumbnails(ctx context.Context, tenantID string, imageId string, imageData io.Reader) {
+	if s.Thumbnailer == nil {
+		return
+	}
+	info, err := s.ImageStorage.Get(tenantID, imageId)
+	if err != nil || info == nil {
+		log.Printf("cannot get image %v: %v", imageId, err)
+		return
+	}
+	missing := false
+	for _, size := range s.Thumbnailer.Sizes() {
+		if info.Thumbnail(size) == nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return
+	}
+
+	thumbnails, ext, err := s.Thumbnailer.Generate(imageData)
+	if err != nil {
+		log.Printf("cannot generate thumbnails of image %v: %v", imageId, err)
+		return
+	}
+	for _, size := range s.Thumbnailer.Sizes() {
+		err := s.ImageStorage.SaveThumbnail(ctx, tenantID, imageId, size, ext, *thumbnails[size])
+		if err != nil {
+			log.Printf("cannot save %d thumbnail of image %v: %v", size, imageId, err)
+		}
+	}
+}
+
+func (s *LaptopServer) ListLaptopImages(
+	ctx context.Context,
+	req *pb.ListLaptopImagesRequest,
+) (*pb.ListLaptopImagesResponse, error) {
+	laptopId := req.GetLaptopId()
+	tenantID := tenantFromContext(ctx)
+	laptop, err := s.LaptopStorage.Get(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+	}
+
+	images, err := s.ImageStorage.List(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list images of laptop with id %v: %v", laptopId, err)
+	}
+	resp := &pb.ListLaptopImagesResponse{
+		Images: make([]*pb.Image, 0, len(images)),
+	}
+	for _, image := range images {
+		resp.Images = append(resp.Images, imageInfoToPb(image))
+	}
+	return resp, nil
+}
+
+func (s *LaptopServer) DeleteImage(
+	ctx context.Context,
+	req *pb.DeleteImageRequest,
+) (*pb.DeleteImageResponse, error) {
+	imageId := req.GetImageId()
+	tenantID := tenantFromContext(ctx)
+	info, err := s.ImageStorage.Get(tenantID, imageId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get image with id %v: %v", imageId, err)
+	}
+	if info == nil {
+		return nil, status.Errorf(codes.NotFound, "image id %v is not found", imageId)
+	}
+	_, err = s.getOwnedLaptop(ctx, info.LaptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ImageStorage.Delete(ctx, tenantID, imageId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot delete image with id %v: %v", imageId, err)
+	}
+	log.Printf("delete image with id %v of laptop with id %v", imageId, info.LaptopID)
+	return &pb.DeleteImageResponse{}, nil
+}
+
+func (s *LaptopServer) SetPrimaryImage(
+	ctx context.Context,
+	req *pb.SetPrimaryImageRequest,
+) (*pb.SetPrimaryImageResponse, error) {
+	laptopId := req.GetLaptopId()
+	laptop, err := s.getOwnedLaptop(ctx, laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantID := tenantFromContext(ctx)
+	err = s.ImageStorage.SetPrimary(tenantID, laptopId, req.GetImageId())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot set primary image of laptop with id %v: %v", laptopId, err)
+	}
+	err = s.attachImages(tenantID, laptop)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetPrimaryImageResponse{Laptop: laptop}, nil
+}
+
+func (s *LaptopServer) ReorderImages(
+	ctx context.Context,
+	req *pb.ReorderImagesRequest,
+) (*pb.ReorderImagesResponse, error) {
+	laptopId := req.GetLaptopId()
+	laptop, err := s.getOwnedLaptop(ctx, laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantID := tenantFromContext(ctx)
+	err = s.ImageStorage.Reorder(tenantID, laptopId, req.GetImageIds())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrInvalidOrder) {
+			code = codes.InvalidArgument
+		}
+		return nil, status.Errorf(code, "cannot reorder images of laptop with id %v: %v", laptopId, err)
+	}
+	err = s.attachImages(tenantID, laptop)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReorderImagesResponse{Laptop: laptop}, nil
+}
+
+// getOwnedLaptop returns the laptop which may be modified by the principal.
+func (s *LaptopServer) getOwnedLaptop(ctx context.Context, laptopId string) (*pb.Laptop, error) {
+	laptop, err := s.LaptopStorage.Get(tenantFromContext(ctx), laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+	}
+	err = checkOwnership(ctx, laptop)
+	if err != nil {
+		return nil, err
+	}
+	return laptop, nil
+}
+
+// attachImages sets the ordered images and the primary image of the laptop.
+func (s *LaptopServer) attachImages(tenantID string, laptop *pb.Laptop) error {
+	images, err := s.ImageStorage.List(tenantID, laptop.GetId())
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot list images of laptop with id %v: %v", laptop.GetId(), err)
+	}
+	primary, err := s.ImageStorage.Primary(tenantID, laptop.GetId())
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot get primary image of laptop with id %v: %v", laptop.GetId(), err)
+	}
+	laptop.Images = make([]*pb.Image, 0, len(images))
+	for _, image := range images {
+		laptop.Images = append(laptop.Images, imageInfoToPb(image))
+	}
+	laptop.PrimaryImageId = primary
+	return nil
+}
+
+func (s *LaptopServer) DownloadImage(
+	req *pb.DownloadImageRequest,
+	stream grpc.ServerStreamingServer[pb.DownloadImageResponse],
+) error {
+	imageId := req.GetImageId()
+	size := req.GetSize()
+	log.Printf("receive a download request of image with id: %v, size: %v", imageId, size)
+
+	tenantID := tenantFromContext(stream.Context())
+	info, err := s.ImageStorage.Get(tenantID, imageId)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot get image with id %v: %v", imageId, err)
+	}
+	if info == nil {
+		return status.Errorf(codes.NotFound, "image id %v is not found", imageId)
+	}
+	image := imageInfoToPb(info)
+	if size > 0 {
+		thumbnail := info.Thumbnail(size)
+		if thumbnail == nil {
+			return status.Errorf(codes.NotFound, "thumbnail of size %v is not found for image id %v", size, imageId)
+		}
+		image.ImageType = thumbnail.Type
+		image.ByteSize = uint64(thumbnail.ByteSize)
+	}
+	file, err := s.ImageStorage.Open(stream.Context(), tenantID, imageId, size)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot open image with id %v: %v", imageId, err)
+	}
+	defer file.Close()
+
+	err = stream.Send(&pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: image,
+		},
+	})
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send image info: %v", err)
+	}
+
+	// only original image can be checked, thumbnails have no digest
+	hash := sha256.New()
+	buffer := make([]byte, downloadChunkSize)
+	for {
+		if stream.Context().Err() == context.Canceled {
+			log.Println("context cancelled")
+			return status.Error(codes.Canceled, "cancelled context")
+		}
+
+		if stream.Context().Err() == context.DeadlineExceeded {
+			log.Println("deadline exceeded")
+			return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+		}
+		n, err := file.Read(buffer)
+		if n > 0 {
+			hash.Write(buffer[:n])
+			err := stream.Send(&pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			})
+			if err != nil {
+				return status.Errorf(codes.Unknown, "cannot send chunk: %v", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot read image data: %v", err)
+		}
+	}
+	if size == 0 && hex.EncodeToString(hash.Sum(nil)) != info.Digest {
+		log.Printf("image with id %v is corrupted", imageId)
+		return status.Errorf(codes.DataLoss, "image with id %v is corrupted", imageId)
+	}
+	log.Printf("image with id %v sent successfully", imageId)
+	return nil
+}
+
 func (s *LaptopServer) RateLaptop(stream grpc.BidiStreamingServer[pb.RateLaptopRequest, pb.RateLaptopResponse]) error {
+	tenantID := tenantFromContext(stream.Context())
+	username := usernameFromContext(stream.Context())
+	if len(username) == 0 {
+		return status.Error(codes.Unauthenticated, "only authenticated users can rate laptops")
+	}
 	for {
 		if stream.Context().Err() == context.Canceled {
 			log.Println("context cancelled")
@@ -211,15 +708,28 @@ func (s *LaptopServer) RateLaptop(stream grpc.BidiStreamingServer[pb.RateLaptopR
 
 		log.Printf("reveive a rate for laptop with id: %s, score: %.2f", laptopId, score)
 
-		found, err := s.LaptopStorage.Get(laptopId)
+		// invalid requests are rejected one by one without closing the stream
+		err = s.RatingScale.Validate(score)
+		if err != nil {
+			err = sendRatingError(stream, laptopId, status.Newf(codes.InvalidArgument, "invalid score: %v", err))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		found, err := s.LaptopStorage.Get(tenantID, laptopId)
 		if err != nil {
 			return status.Errorf(codes.Internal, "cannot find laptop with id: %v: (%v)", laptopId, err)
 		}
 		if found == nil {
-			return status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+			err = sendRatingError(stream, laptopId, status.Newf(codes.NotFound, "laptop id %v is not found", laptopId))
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		rating, err := s.RatingStorage.Add(laptopId, score)
+		rating, err := s.RatingStorage.Rate(tenantID, laptopId, username, score)
 		if err != nil {
 			return status.Errorf(codes.Internal, "cannot rate the laptop with id: %v: (%v)", laptopId, err)
 		}
@@ -228,6 +738,7 @@ func (s *LaptopServer) RateLaptop(stream grpc.BidiStreamingServer[pb.RateLaptopR
 			LaptopId:     laptopId,
 			RatedCount:   rating.Count,
 			AvarageScore: rating.Sum / float64(rating.Count),
+			AverageScore: rating.Sum / float64(rating.Count),
 		}
 		err = stream.Send(resp)
 		if err != nil {
@@ -236,3 +747,33 @@ func (s *LaptopServer) RateLaptop(stream grpc.BidiStreamingServer[pb.RateLaptopR
 	}
 	return nil
 }
+
+func imageInfoToPb(info *storage.ImageInfo) *pb.Image {
+	res := &pb.Image{
+		Id:         info.ID,
+		LaptopId:   info.LaptopID,
+		ImageType:  info.Type,
+		ByteSize:   uint64(info.Size),
+		UploadedBy: info.UploadedBy,
+		UploadedAt: timestamppb.New(info.UploadedAt),
+		Digest:     info.Digest,
+	}
+	for _, thumbnail := range info.Thumbnails {
+		res.ThumbnailSizes = append(res.ThumbnailSizes, thumbnail.Size)
+	}
+	return res
+}
+
+// checkOwnership allows to modify the laptop only to its creator or to
+// the principal with user:admin permission. Requests without principal
+// come from the server used without AuthInterceptor and are allowed.
+func checkOwnership(ctx context.Context, laptop *pb.Laptop) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasPermission(PermissionUserAdmin) {
+		return nil
+	}
+	if laptop.GetCreatedBy() != principal.Username {
+		return status.Errorf(codes.PermissionDenied, "laptop %v is owned by another user", laptop.GetId())
+	}
+	return nil
+}
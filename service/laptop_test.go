@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"context"
+	"main/models"
 	"main/pb"
 	"main/sample"
 	"main/service"
@@ -23,7 +24,7 @@ func TestServerCreateLaptop(t *testing.T) {
 
 	laptopForTestDuplicateId := sample.NewLaptop()
 	storageWithDuplicateLaptop := storage.NewInMemoryLaptopStorage()
-	err := storageWithDuplicateLaptop.Save(laptopForTestDuplicateId)
+	err := storageWithDuplicateLaptop.Save(models.DefaultTenantID, laptopForTestDuplicateId)
 	require.NoError(t, err)
 
 	testCasess := []struct {
@@ -65,7 +66,7 @@ func TestServerCreateLaptop(t *testing.T) {
 				Laptop: tc.laptop,
 			}
 			ctx := context.Background()
-			server := service.NewLaptopServer(tc.storage, nil, nil)
+			server := service.NewLaptopServer(tc.storage, service.LaptopServerOptions{})
 			res, err := server.CreateLaptop(ctx, req)
 			if tc.expectedCode == codes.OK {
 				require.NoError(t, err)
@@ -82,3 +83,101 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCreateLaptopRecordsCreator(t *testing.T) {
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{})
+	laptop := sample.NewLaptop()
+	laptop.CreatedBy = "spoofed"
+	ctx := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "admin", Role: "admin"})
+
+	res, err := server.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	saved, err := laptopStorage.Get(models.DefaultTenantID, res.GetId())
+	require.NoError(t, err)
+	require.Equal(t, "admin", saved.GetCreatedBy())
+}
+
+func TestServerUpdateLaptopOwnership(t *testing.T) {
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{})
+	principal := func(username, role string) context.Context {
+		return service.ContextWithPrincipal(context.Background(), &service.Principal{
+			Username:    username,
+			Role:        role,
+			Permissions: service.RolePermissions(role),
+		})
+	}
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(principal("vendor1", "vendor"), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		ctx          context.Context
+		expectedCode codes.Code
+	}{
+		{name: "owner", ctx: principal("vendor1", "vendor"), expectedCode: codes.OK},
+		{name: "another vendor", ctx: principal("vendor2", "vendor"), expectedCode: codes.PermissionDenied},
+		{name: "admin", ctx: principal("admin", "admin"), expectedCode: codes.OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			update := sample.NewLaptop()
+			update.Id = laptop.GetId()
+			res, err := server.UpdateLaptop(tc.ctx, &pb.UpdateLaptopRequest{Laptop: update})
+			if tc.expectedCode == codes.OK {
+				require.NoError(t, err)
+				require.Equal(t, "vendor1", res.GetLaptop().GetCreatedBy())
+			} else {
+				require.Error(t, err)
+				state, ok := status.FromError(err)
+				require.True(t, ok)
+				require.Equal(t, tc.expectedCode, state.Code())
+			}
+		})
+	}
+}
+
+func TestServerTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{})
+	adminOf := func(tenantID string) context.Context {
+		return service.ContextWithPrincipal(context.Background(), &service.Principal{
+			Username:    "admin-" + tenantID,
+			Role:        "admin",
+			TenantID:    tenantID,
+			Permissions: service.RolePermissions("admin"),
+		})
+	}
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(adminOf("shop-a"), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	var found []string
+	err = laptopStorage.Search(context.Background(), "shop-b", &pb.Filter{MaxPriceUsd: 1e6}, func(laptop *pb.Laptop) error {
+		found = append(found, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	_, err = server.UpdateLaptop(adminOf("shop-b"), &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.Error(t, err)
+	state, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.NotFound, state.Code())
+
+	_, err = server.UpdateLaptop(adminOf("shop-a"), &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+}
@@ -0,0 +1,55 @@
+package service
+
+const (
+	PermissionLaptopCreate   = "laptop:create"
+	PermissionLaptopUpdate   = "laptop:update"
+	PermissionImageUpload    = "image:upload"
+	PermissionRatingWrite    = "rating:write"
+	PermissionUserAdmin      = "user:admin"
+	PermissionTenantAdmin    = "tenant:admin"
+	PermissionReviewModerate = "review:moderate"
+)
+
+// rolePermissions are the permissions embedded into the token of the user
+// with the role. Vendor may modify only laptops it created, see checkOwnership.
+// Only superadmin manages tenants, admin manages its own tenant.
+var rolePermissions = map[string][]string{
+	"superadmin": {
+		PermissionLaptopCreate,
+		PermissionLaptopUpdate,
+		PermissionImageUpload,
+		PermissionRatingWrite,
+		PermissionUserAdmin,
+		PermissionTenantAdmin,
+		PermissionReviewModerate,
+	},
+	"admin": {
+		PermissionLaptopCreate,
+		PermissionLaptopUpdate,
+		PermissionImageUpload,
+		PermissionRatingWrite,
+		PermissionUserAdmin,
+		PermissionReviewModerate,
+	},
+	"vendor": {
+		PermissionLaptopCreate,
+		PermissionLaptopUpdate,
+		PermissionImageUpload,
+	},
+	"user": {
+		PermissionRatingWrite,
+	},
+}
+
+func RolePermissions(role string) []string {
+	return append([]string(nil), rolePermissions[role]...)
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
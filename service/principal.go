@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"main/models"
+)
+
+// Principal is the authenticated caller of the RPC.
+type Principal struct {
+	Username    string
+	Role        string
+	TenantID    string
+	Permissions []string
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	return hasPermission(p.Permissions, permission)
+}
+
+type principalKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns principal attached by AuthInterceptor.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// usernameFromContext returns username of the principal or empty
+// string if the request is anonymous.
+func usernameFromContext(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return principal.Username
+}
+
+// tenantFromContext returns tenant of the principal. Anonymous
+// requests work with the default tenant.
+func tenantFromContext(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || len(principal.TenantID) == 0 {
+		return models.DefaultTenantID
+	}
+	return principal.TenantID
+}
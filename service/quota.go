@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"main/pb"
+	"main/storage"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxImageSize = 1 << 20
+)
+
+// UploadLimits restrict image uploads of a user. Zero means no limit.
+type UploadLimits struct {
+	MaxImageSize       int64
+	MaxImagesPerLaptop int
+	// StorageQuota is the total size of images uploaded by the user.
+	StorageQuota int64
+}
+
+// QuotaPolicy keeps upload limits of roles, roles without
+// own limits and anonymous users get the default ones.
+type QuotaPolicy struct {
+	defaults UploadLimits
+	roles    map[string]UploadLimits
+}
+
+func NewQuotaPolicy(defaults UploadLimits, roles map[string]UploadLimits) *QuotaPolicy {
+	policy := &QuotaPolicy{
+		defaults: defaults,
+		roles:    make(map[string]UploadLimits, len(roles)),
+	}
+	for role, limits := range roles {
+		policy.roles[role] = limits
+	}
+	return policy
+}
+
+// DefaultQuotaPolicy limits only the size of a single image.
+func DefaultQuotaPolicy() *QuotaPolicy {
+	return NewQuotaPolicy(UploadLimits{MaxImageSize: defaultMaxImageSize}, nil)
+}
+
+func (p *QuotaPolicy) Limits(role string) UploadLimits {
+	if limits, ok := p.roles[role]; ok {
+		return limits
+	}
+	return p.defaults
+}
+
+func (s *LaptopServer) GetQuota(
+	ctx context.Context,
+	req *pb.GetQuotaRequest,
+) (*pb.GetQuotaResponse, error) {
+	limits := s.uploadLimits(ctx)
+	count, usedBytes, err := s.ImageStorage.Usage(tenantFromContext(ctx), usernameFromContext(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get storage usage: %v", err)
+	}
+	return &pb.GetQuotaResponse{
+		MaxImageSize:       uint64(limits.MaxImageSize),
+		MaxImagesPerLaptop: uint32(limits.MaxImagesPerLaptop),
+		StorageQuota:       uint64(limits.StorageQuota),
+		UsedBytes:          uint64(usedBytes),
+		ImageCount:         uint32(count),
+	}, nil
+}
+
+func (s *LaptopServer) uploadLimits(ctx context.Context) UploadLimits {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return s.QuotaPolicy.Limits("")
+	}
+	return s.QuotaPolicy.Limits(principal.Role)
+}
+
+// checkImageSize is called for every received chunk, so oversized images
+// are rejected before they are received. It only compares the size with
+// the limits, the storage usage is checked by checkStorageQuota.
+func (s *LaptopServer) checkImageSize(ctx context.Context, size int64) error {
+	limits := s.uploadLimits(ctx)
+	if limits.MaxImageSize > 0 && size > limits.MaxImageSize {
+		return quotaExceeded(
+			"image",
+			fmt.Sprintf("image is too large: %d > %d", size, limits.MaxImageSize),
+		)
+	}
+	if limits.StorageQuota > 0 && size > limits.StorageQuota {
+		return quotaExceeded(
+			"image",
+			fmt.Sprintf("image is larger than storage quota: %d > %d", size, limits.StorageQuota),
+		)
+	}
+	return nil
+}
+
+// checkStorageQuota scans images of the uploader, so it is called once
+// when the upload starts and once when it is finished. Images of other
+// uploads of the user in progress are counted as used, so the quota
+// cannot be exceeded by starting many uploads at once.
+func (s *LaptopServer) checkStorageQuota(ctx context.Context, session *storage.UploadSession, size int64) error {
+	limits := s.uploadLimits(ctx)
+	if limits.StorageQuota <= 0 {
+		return nil
+	}
+	_, usedBytes, err := s.ImageStorage.Usage(session.TenantID, session.CreatedBy)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot get storage usage: %v", err)
+	}
+	pendingBytes, err := s.UploadStorage.PendingBytes(session.TenantID, session.CreatedBy, session.ID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot get size of uploads in progress: %v", err)
+	}
+	usedBytes += pendingBytes
+	if usedBytes+size > limits.StorageQuota {
+		return quotaExceeded(
+			fmt.Sprintf("user:%s", session.CreatedBy),
+			fmt.Sprintf("storage quota is exceeded: %d + %d > %d", usedBytes, size, limits.StorageQuota),
+		)
+	}
+	return nil
+}
+
+// checkImageCount rejects new images of the laptop which already has
+// the maximum number of images. It is checked before the content is known,
+// so upload of the image the laptop already has is rejected as well.
+func (s *LaptopServer) checkImageCount(ctx context.Context, tenantID string, laptopId string) error {
+	limits := s.uploadLimits(ctx)
+	if limits.MaxImagesPerLaptop <= 0 {
+		return nil
+	}
+	images, err := s.ImageStorage.List(tenantID, laptopId)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot list images of laptop with id %v: %v", laptopId, err)
+	}
+	if len(images) >= limits.MaxImagesPerLaptop {
+		return quotaExceeded(
+			fmt.Sprintf("laptop:%s", laptopId),
+			fmt.Sprintf("laptop has maximum number of images: %d", limits.MaxImagesPerLaptop),
+		)
+	}
+	return nil
+}
+
+func quotaExceeded(subject string, description string) error {
+	st := status.New(codes.ResourceExhausted, description)
+	detailed, err := st.WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: subject, Description: description},
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
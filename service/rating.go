@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"main/pb"
+	"main/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *LaptopServer) GetRatingScale(
+	ctx context.Context,
+	req *pb.GetRatingScaleRequest,
+) (*pb.GetRatingScaleResponse, error) {
+	return &pb.GetRatingScaleResponse{
+		Min:  s.RatingScale.Min,
+		Max:  s.RatingScale.Max,
+		Step: s.RatingScale.Step,
+	}, nil
+}
+
+func (s *LaptopServer) GetLaptopRating(
+	ctx context.Context,
+	req *pb.GetLaptopRatingRequest,
+) (*pb.GetLaptopRatingResponse, error) {
+	laptopId := req.GetLaptopId()
+	tenantID := tenantFromContext(ctx)
+	laptop, err := s.LaptopStorage.Get(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+	}
+
+	scores, err := s.RatingStorage.Scores(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get scores of laptop with id %v: %v", laptopId, err)
+	}
+	values := make([]float64, 0, len(scores))
+	for _, score := range scores {
+		values = append(values, score.Value)
+	}
+	stats := s.RatingScale.NewRatingStats(values)
+
+	resp := &pb.GetLaptopRatingResponse{
+		LaptopId: laptopId,
+		Summary: &pb.RatingSummary{
+			RatedCount:      stats.Count,
+			AverageScore:    stats.Average,
+			BayesianAverage: stats.BayesianAverage,
+		},
+		Median:            stats.Median,
+		StandardDeviation: stats.StandardDeviation,
+	}
+	for i, count := range stats.Histogram {
+		resp.Histogram = append(resp.Histogram, &pb.RatingBucket{
+			Score: s.RatingScale.BucketScore(i),
+			Count: count,
+		})
+	}
+	return resp, nil
+}
+
+func (s *LaptopServer) DeleteMyRating(
+	ctx context.Context,
+	req *pb.DeleteMyRatingRequest,
+) (*pb.DeleteMyRatingResponse, error) {
+	laptopId := req.GetLaptopId()
+	username := usernameFromContext(ctx)
+	if len(username) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "only authenticated users have ratings")
+	}
+
+	rating, err := s.RatingStorage.Delete(tenantFromContext(ctx), laptopId, username)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot delete rating of laptop with id %v: %v", laptopId, err)
+	}
+	log.Printf("delete rating of laptop with id %v by %q", laptopId, username)
+
+	resp := &pb.DeleteMyRatingResponse{
+		LaptopId:   laptopId,
+		RatedCount: rating.Count,
+	}
+	if rating.Count > 0 {
+		resp.AverageScore = rating.Sum / float64(rating.Count)
+	}
+	return resp, nil
+}
+
+func sendRatingError(
+	stream grpc.BidiStreamingServer[pb.RateLaptopRequest, pb.RateLaptopResponse],
+	laptopId string,
+	st *status.Status,
+) error {
+	log.Printf("reject a rate for laptop with id %v: %v", laptopId, st.Message())
+	err := stream.Send(&pb.RateLaptopResponse{
+		LaptopId: laptopId,
+		Error:    st.Proto(),
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot send response: %v", err)
+	}
+	return nil
+}
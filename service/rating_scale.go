@@ -0,0 +1,42 @@
+package service
+
+import (
+	"fmt"
+	"main/models"
+	"math"
+)
+
+// RatingScale is the set of allowed scores: values from Min
+// to Max inclusive which differ from Min by a multiple of Step.
+type RatingScale struct {
+	Min  float64
+	Max  float64
+	Step float64
+}
+
+func NewRatingScale(min float64, max float64, step float64) (*RatingScale, error) {
+	err := models.ValidateRatingScale(min, max, step)
+	if err != nil {
+		return nil, err
+	}
+	return &RatingScale{Min: min, Max: max, Step: step}, nil
+}
+
+// DefaultRatingScale allows scores from 1 to 10 in 0.5 steps.
+func DefaultRatingScale() *RatingScale {
+	scale, _ := NewRatingScale(1, 10, 0.5)
+	return scale
+}
+
+func (s *RatingScale) Validate(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("score must be finite")
+	}
+	if score < s.Min || score > s.Max {
+		return fmt.Errorf("score %v is out of range [%v, %v]", score, s.Min, s.Max)
+	}
+	if !models.OnRatingStep(s.Min, s.Step, score) {
+		return fmt.Errorf("score %v is not a multiple of step %v", score, s.Step)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"main/service"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRatingScale(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		min   float64
+		max   float64
+		step  float64
+		valid bool
+	}{
+		{name: "half steps", min: 1, max: 5, step: 0.5, valid: true},
+		{name: "integers", min: 1, max: 10, step: 1, valid: true},
+		{name: "tenths", min: 0, max: 1, step: 0.1, valid: true},
+		{name: "empty range", min: 5, max: 5, step: 1},
+		{name: "reversed range", min: 10, max: 1, step: 1},
+		{name: "zero step", min: 1, max: 5, step: 0},
+		{name: "indivisible range", min: 1, max: 5, step: 3},
+		{name: "infinite", min: 1, max: math.Inf(1), step: 1},
+		{name: "nan", min: math.NaN(), max: 5, step: 1},
+		{name: "max scores", min: 1, max: 1000, step: 1, valid: true},
+		{name: "too many scores", min: 1, max: 1e6, step: 1e-4},
+	}
+
+	for _, tc := range testCases {
+		_, err := service.NewRatingScale(tc.min, tc.max, tc.step)
+		if tc.valid {
+			require.NoError(t, err, tc.name)
+		} else {
+			require.Error(t, err, tc.name)
+		}
+	}
+}
+
+func TestRatingScaleValidate(t *testing.T) {
+	t.Parallel()
+
+	scale, err := service.NewRatingScale(1, 5, 0.5)
+	require.NoError(t, err)
+
+	for _, score := range []float64{1, 1.5, 3, 4.5, 5} {
+		require.NoError(t, scale.Validate(score), score)
+	}
+	for _, score := range []float64{0.5, 5.5, -1, 1.25, 1e308, math.NaN(), math.Inf(-1)} {
+		require.Error(t, scale.Validate(score), score)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"main/pb"
+	"main/storage"
+	"math"
+	"slices"
+)
+
+// bayesianWeight is the number of virtual scores in the middle of the
+// rating scale added to the scores of the laptop by the Bayesian average.
+const bayesianWeight = 10
+
+// RatingStats describes the distribution of the scores of the laptop.
+type RatingStats struct {
+	Count             uint32
+	Average           float64
+	BayesianAverage   float64
+	Median            float64
+	StandardDeviation float64
+	// Histogram has the number of scores for every score of the scale.
+	Histogram []uint32
+}
+
+func (s *RatingScale) bayesianAverage(rating *storage.Rating) float64 {
+	prior := (s.Min + s.Max) / 2
+	return (bayesianWeight*prior + rating.Sum) / (bayesianWeight + float64(rating.Count))
+}
+
+// NewRatingStats calculates statistics of the scores. Scores which are not on
+// the scale, for example given before the scale was changed, are counted in
+// the histogram bucket of the closest allowed score.
+func (s *RatingScale) NewRatingStats(scores []float64) *RatingStats {
+	stats := &RatingStats{
+		Count:     uint32(len(scores)),
+		Histogram: make([]uint32, s.bucketCount()),
+	}
+	rating := &storage.Rating{Count: stats.Count}
+	for _, score := range scores {
+		rating.Sum += score
+		stats.Histogram[s.bucket(score)]++
+	}
+	stats.BayesianAverage = s.bayesianAverage(rating)
+	if len(scores) == 0 {
+		return stats
+	}
+
+	stats.Average = rating.Sum / float64(len(scores))
+	variance := 0.0
+	for _, score := range scores {
+		variance += (score - stats.Average) * (score - stats.Average)
+	}
+	stats.StandardDeviation = math.Sqrt(variance / float64(len(scores)))
+
+	sorted := slices.Clone(scores)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	stats.Median = sorted[middle]
+	if len(sorted)%2 == 0 {
+		stats.Median = (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return stats
+}
+
+// BucketScore returns the score of the histogram bucket.
+func (s *RatingScale) BucketScore(bucket int) float64 {
+	return s.Min + float64(bucket)*s.Step
+}
+
+func (s *RatingScale) bucketCount() int {
+	return int(math.Round((s.Max-s.Min)/s.Step)) + 1
+}
+
+func (s *RatingScale) bucket(score float64) int {
+	bucket := int(math.Round((score - s.Min) / s.Step))
+	return min(max(bucket, 0), s.bucketCount()-1)
+}
+
+func (s *RatingScale) ratingSummary(rating *storage.Rating) *pb.RatingSummary {
+	summary := &pb.RatingSummary{
+		RatedCount:      rating.Count,
+		BayesianAverage: s.bayesianAverage(rating),
+	}
+	if rating.Count > 0 {
+		summary.AverageScore = rating.Sum / float64(rating.Count)
+	}
+	return summary
+}
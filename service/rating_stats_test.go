@@ -0,0 +1,50 @@
+package service_test
+
+import (
+	"main/service"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingStats(t *testing.T) {
+	t.Parallel()
+
+	scale, err := service.NewRatingScale(1, 5, 1)
+	require.NoError(t, err)
+
+	stats := scale.NewRatingStats([]float64{5, 1, 4, 4})
+	require.EqualValues(t, 4, stats.Count)
+	require.Equal(t, 3.5, stats.Average)
+	require.Equal(t, 4.0, stats.Median)
+	require.InDelta(t, math.Sqrt(2.25), stats.StandardDeviation, 1e-9)
+	require.Equal(t, []uint32{1, 0, 0, 2, 1}, stats.Histogram)
+	require.InDelta(t, (10*3+14)/14.0, stats.BayesianAverage, 1e-9)
+
+	stats = scale.NewRatingStats([]float64{2, 5, 4})
+	require.Equal(t, 4.0, stats.Median)
+
+	// scores out of the scale are counted in the closest bucket
+	stats = scale.NewRatingStats([]float64{0.5, 2.4, 7})
+	require.Equal(t, []uint32{1, 1, 0, 0, 1}, stats.Histogram)
+
+	stats = scale.NewRatingStats(nil)
+	require.Zero(t, stats.Count)
+	require.Zero(t, stats.Average)
+	require.Equal(t, 3.0, stats.BayesianAverage)
+	require.Len(t, stats.Histogram, 5)
+	require.Equal(t, 3.0, scale.BucketScore(2))
+}
+
+func TestRatingStatsBayesianAverage(t *testing.T) {
+	t.Parallel()
+
+	scale := service.DefaultRatingScale()
+	single := scale.NewRatingStats([]float64{10})
+	many := make([]float64, 200)
+	for i := range many {
+		many[i] = 9
+	}
+	require.Greater(t, scale.NewRatingStats(many).BayesianAverage, single.BayesianAverage)
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"main/pb"
+	"main/storage"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultHistoryBuckets     = 12
+	maxHistoryBuckets         = 1000
+	defaultTrendingWindowDays = 7
+	maxTrendingWindowDays     = 366
+	defaultTrendingLimit      = 10
+	maxTrendingLimit          = 100
+)
+
+var historyBucketSizes = map[pb.GetRatingHistoryRequest_BucketSize]time.Duration{
+	pb.GetRatingHistoryRequest_UNSPECIFIED: 7 * 24 * time.Hour,
+	pb.GetRatingHistoryRequest_HOUR:        time.Hour,
+	pb.GetRatingHistoryRequest_DAY:         24 * time.Hour,
+	// the zero time is Monday, so truncated weeks start on Monday
+	pb.GetRatingHistoryRequest_WEEK: 7 * 24 * time.Hour,
+}
+
+type ratingReplayKey struct {
+	ratedBy string
+	source  string
+}
+
+// ratingReplay is the score of every user from every source built from
+// rating events.
+type ratingReplay map[ratingReplayKey]float64
+
+func (r ratingReplay) apply(event *storage.RatingEvent) {
+	key := ratingReplayKey{ratedBy: event.RatedBy, source: event.Source}
+	if event.Deleted {
+		delete(r, key)
+		return
+	}
+	r[key] = event.Score
+}
+
+// rating counts every user once, the score of the approved review
+// takes precedence as it does in the rating storage.
+func (r ratingReplay) rating() *storage.Rating {
+	rating := &storage.Rating{}
+	for key, score := range r {
+		if key.source != storage.RatingSourceReview {
+			if _, ok := r[ratingReplayKey{ratedBy: key.ratedBy, source: storage.RatingSourceReview}]; ok {
+				continue
+			}
+		}
+		rating.Count++
+		rating.Sum += score
+	}
+	return rating
+}
+
+func (s *LaptopServer) GetRatingHistory(
+	ctx context.Context,
+	req *pb.GetRatingHistoryRequest,
+) (*pb.GetRatingHistoryResponse, error) {
+	bucketSize, ok := historyBucketSizes[req.GetBucketSize()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown bucket size %v", req.GetBucketSize())
+	}
+	to := time.Now()
+	if req.GetTo() != nil {
+		to = req.GetTo().AsTime()
+	}
+	if aligned := to.Truncate(bucketSize); aligned.Before(to) {
+		to = aligned.Add(bucketSize)
+	}
+	from := to.Add(-defaultHistoryBuckets * bucketSize)
+	if req.GetFrom() != nil {
+		from = req.GetFrom().AsTime().Truncate(bucketSize)
+	}
+	if !from.Before(to) {
+		return nil, status.Errorf(codes.InvalidArgument, "history start %v must be before its end %v", from, to)
+	}
+	if to.Sub(from)/bucketSize > maxHistoryBuckets {
+		return nil, status.Errorf(codes.InvalidArgument, "history must have at most %v buckets", maxHistoryBuckets)
+	}
+
+	laptopId := req.GetLaptopId()
+	tenantID := tenantFromContext(ctx)
+	laptop, err := s.LaptopStorage.Get(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+	}
+	events, err := s.RatingStorage.Events(tenantID, laptopId, to)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get rating events of laptop with id %v: %v", laptopId, err)
+	}
+
+	resp := &pb.GetRatingHistoryResponse{LaptopId: laptopId}
+	replay := ratingReplay{}
+	next := 0
+	for start := from; start.Before(to); start = start.Add(bucketSize) {
+		end := start.Add(bucketSize)
+		bucket := &pb.RatingHistoryBucket{
+			Start: timestamppb.New(start),
+			End:   timestamppb.New(end),
+		}
+		sum := 0.0
+		for ; next < len(events) && events[next].Time.Before(end); next++ {
+			event := events[next]
+			replay.apply(event)
+			if !event.Deleted && !event.Time.Before(start) {
+				bucket.NewScores++
+				sum += event.Score
+			}
+		}
+		if bucket.NewScores > 0 {
+			bucket.NewAverageScore = sum / float64(bucket.NewScores)
+		}
+		bucket.Rating = s.RatingScale.ratingSummary(replay.rating())
+		resp.Buckets = append(resp.Buckets, bucket)
+	}
+	return resp, nil
+}
+
+// laptopTrend is the rating of the laptop before the trending window
+// and the scores given during it.
+type laptopTrend struct {
+	laptopId string
+	replay   ratingReplay
+	before   *storage.Rating
+	recent   uint32
+	delta    float64
+	score    float64
+}
+
+func (s *LaptopServer) TrendingLaptops(
+	ctx context.Context,
+	req *pb.TrendingLaptopsRequest,
+) (*pb.TrendingLaptopsResponse, error) {
+	windowDays := req.GetWindowDays()
+	if windowDays == 0 {
+		windowDays = defaultTrendingWindowDays
+	}
+	if windowDays > maxTrendingWindowDays {
+		return nil, status.Errorf(codes.InvalidArgument, "window must be at most %v days", maxTrendingWindowDays)
+	}
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultTrendingLimit
+	}
+	limit = min(limit, maxTrendingLimit)
+
+	tenantID := tenantFromContext(ctx)
+	now := time.Now()
+	since := now.Add(-time.Duration(windowDays) * 24 * time.Hour)
+	events, err := s.RatingStorage.Events(tenantID, "", now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get rating events: %v", err)
+	}
+
+	trendsByLaptop := make(map[string]*laptopTrend)
+	for _, event := range events {
+		trend, ok := trendsByLaptop[event.LaptopID]
+		if !ok {
+			trend = &laptopTrend{laptopId: event.LaptopID, replay: ratingReplay{}}
+			trendsByLaptop[event.LaptopID] = trend
+		}
+		if !event.Time.Before(since) {
+			if trend.before == nil {
+				trend.before = trend.replay.rating()
+			}
+			if !event.Deleted {
+				trend.recent++
+			}
+		}
+		trend.replay.apply(event)
+	}
+
+	scaleRange := s.RatingScale.Max - s.RatingScale.Min
+	trends := make([]*laptopTrend, 0)
+	for _, trend := range trendsByLaptop {
+		if trend.recent == 0 {
+			continue
+		}
+		trend.delta = s.RatingScale.bayesianAverage(trend.replay.rating()) - s.RatingScale.bayesianAverage(trend.before)
+		trend.score = float64(trend.recent) * (1 + trend.delta/scaleRange)
+		trends = append(trends, trend)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].score != trends[j].score {
+			return trends[i].score > trends[j].score
+		}
+		if trends[i].recent != trends[j].recent {
+			return trends[i].recent > trends[j].recent
+		}
+		return trends[i].laptopId < trends[j].laptopId
+	})
+
+	resp := &pb.TrendingLaptopsResponse{}
+	for _, trend := range trends {
+		if len(resp.Laptops) == limit {
+			break
+		}
+		laptop, err := s.LaptopStorage.Get(tenantID, trend.laptopId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot find laptop with id %v: %v", trend.laptopId, err)
+		}
+		// the laptop may be deleted meanwhile
+		if laptop == nil {
+			continue
+		}
+		err = s.attachImages(tenantID, laptop)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot get images of laptop with id %v: %v", trend.laptopId, err)
+		}
+		resp.Laptops = append(resp.Laptops, &pb.TrendingLaptop{
+			Laptop:       laptop,
+			RecentScores: trend.recent,
+			ScoreDelta:   trend.delta,
+			TrendScore:   trend.score,
+			Rating:       s.RatingScale.ratingSummary(trend.replay.rating()),
+		})
+	}
+	return resp, nil
+}
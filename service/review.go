@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"main/models"
+	"main/pb"
+	"main/storage"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+type ReviewStorager interface {
+	Save(review *models.Review) (*models.Review, error)
+	Get(tenantID string, id string) (*models.Review, error)
+	List(tenantID string, laptopID string, statuses ...models.ReviewStatus) ([]*models.Review, error)
+	SetStatus(tenantID string, id string, status models.ReviewStatus, moderatedBy string, note string) (*models.Review, error)
+	DeleteByLaptop(tenantID string, laptopID string) error
+}
+
+type ReviewServer struct {
+	reviewStorage ReviewStorager
+	laptopStorage LaptopStorager
+	ratingStorage RatingStorager
+	ratingScale   *RatingScale
+	pb.UnimplementedReviewServiceServer
+}
+
+// NewReviewServer creates review server. Scores of reviews are
+// checked with the default rating scale unless ratingScale is given.
+func NewReviewServer(
+	reviewStorage ReviewStorager,
+	laptopStorage LaptopStorager,
+	ratingStorage RatingStorager,
+	ratingScale *RatingScale,
+) *ReviewServer {
+	if ratingScale == nil {
+		ratingScale = DefaultRatingScale()
+	}
+	return &ReviewServer{
+		reviewStorage: reviewStorage,
+		laptopStorage: laptopStorage,
+		ratingStorage: ratingStorage,
+		ratingScale:   ratingScale,
+	}
+}
+
+func (rs *ReviewServer) SubmitReview(ctx context.Context, req *pb.SubmitReviewRequest) (*pb.SubmitReviewResponse, error) {
+	username := usernameFromContext(ctx)
+	if len(username) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "only authenticated users can submit reviews")
+	}
+	err := rs.ratingScale.Validate(req.GetScore())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid score: %v", err)
+	}
+	tenantID := tenantFromContext(ctx)
+	laptopId := req.GetLaptopId()
+	laptop, err := rs.laptopStorage.Get(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop id %v is not found", laptopId)
+	}
+
+	review, err := models.NewReview(tenantID, laptopId, username, req.GetScore(), req.GetTitle(), req.GetText())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, models.ErrInvalidReview) {
+			code = codes.InvalidArgument
+		}
+		return nil, status.Errorf(code, "cannot create review: %v", err)
+	}
+	// edited review goes back to moderation, so its score stops counting
+	previous, err := rs.approvedReview(tenantID, laptopId, username)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		err = rs.setReviewScore(previous, false)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot update rating of the laptop with id %v: %v", laptopId, err)
+		}
+	}
+	review, err = rs.reviewStorage.Save(review)
+	if err != nil {
+		if previous != nil {
+			rs.restoreReviewScore(previous, true)
+		}
+		return nil, status.Errorf(codes.Internal, "cannot save review: %v", err)
+	}
+	log.Printf("review %v of laptop with id %v submitted by %q", review.ID, laptopId, username)
+	return &pb.SubmitReviewResponse{Review: reviewToPb(review)}, nil
+}
+
+func (rs *ReviewServer) ListReviews(ctx context.Context, req *pb.ListReviewsRequest) (*pb.ListReviewsResponse, error) {
+	reviews, err := rs.reviewStorage.List(tenantFromContext(ctx), req.GetLaptopId(), models.ReviewApproved)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list reviews: %v", err)
+	}
+	slices.Reverse(reviews)
+	page, nextPageToken, err := paginateReviews(reviews, req.GetPageSize(), req.GetPageToken(), true)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListReviewsResponse{
+		Reviews:       reviewsToPb(page),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (rs *ReviewServer) ListModerationQueue(
+	ctx context.Context,
+	req *pb.ListModerationQueueRequest,
+) (*pb.ListModerationQueueResponse, error) {
+	reviews, err := rs.reviewStorage.List(tenantFromContext(ctx), "", models.ReviewPending, models.ReviewFlagged)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list reviews: %v", err)
+	}
+	page, nextPageToken, err := paginateReviews(reviews, req.GetPageSize(), req.GetPageToken(), false)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListModerationQueueResponse{
+		Reviews:       reviewsToPb(page),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (rs *ReviewServer) ModerateReview(ctx context.Context, req *pb.ModerateReviewRequest) (*pb.ModerateReviewResponse, error) {
+	var reviewStatus models.ReviewStatus
+	switch req.GetAction() {
+	case pb.ModerateReviewRequest_APPROVE:
+		reviewStatus = models.ReviewApproved
+	case pb.ModerateReviewRequest_REJECT:
+		reviewStatus = models.ReviewRejected
+	case pb.ModerateReviewRequest_FLAG:
+		reviewStatus = models.ReviewFlagged
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown moderation action %v", req.GetAction())
+	}
+
+	tenantID := tenantFromContext(ctx)
+	review, err := rs.reviewStorage.Get(tenantID, req.GetReviewId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get review %v: %v", req.GetReviewId(), err)
+	}
+	if review == nil {
+		return nil, status.Errorf(codes.NotFound, "review %v is not found", req.GetReviewId())
+	}
+
+	// only scores of approved reviews count in the rating of the laptop
+	wasApproved := review.Status == models.ReviewApproved
+	approved := reviewStatus == models.ReviewApproved
+	if approved != wasApproved {
+		err = rs.setReviewScore(review, approved)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot update rating of the laptop with id %v: %v", review.LaptopID, err)
+		}
+	}
+	moderated, err := rs.reviewStorage.SetStatus(
+		tenantID,
+		review.ID,
+		reviewStatus,
+		usernameFromContext(ctx),
+		req.GetNote(),
+	)
+	if err != nil {
+		if approved != wasApproved {
+			rs.restoreReviewScore(review, wasApproved)
+		}
+		code := codes.Internal
+		if errors.Is(err, storage.ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot moderate review %v: %v", req.GetReviewId(), err)
+	}
+	log.Printf("review %v is %v by %q", moderated.ID, moderated.Status, moderated.ModeratedBy)
+	return &pb.ModerateReviewResponse{Review: reviewToPb(moderated)}, nil
+}
+
+// approvedReview returns the approved review of the author for the laptop or nil.
+func (rs *ReviewServer) approvedReview(tenantID string, laptopId string, author string) (*models.Review, error) {
+	reviews, err := rs.reviewStorage.List(tenantID, laptopId, models.ReviewApproved)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list reviews: %v", err)
+	}
+	for _, review := range reviews {
+		if review.Author == author {
+			return review, nil
+		}
+	}
+	return nil, nil
+}
+
+// setReviewScore adds the score of the review to the rating of the laptop
+// or removes it. The score of the review is kept apart from the score the
+// author gave directly, which counts again when the review score is removed.
+func (rs *ReviewServer) setReviewScore(review *models.Review, counted bool) error {
+	if counted {
+		_, err := rs.ratingStorage.RateReview(review.TenantID, review.LaptopID, review.Author, review.Score)
+		return err
+	}
+	_, err := rs.ratingStorage.DeleteReview(review.TenantID, review.LaptopID, review.Author)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// restoreReviewScore rolls back the rating when the review cannot be saved.
+func (rs *ReviewServer) restoreReviewScore(review *models.Review, counted bool) {
+	err := rs.setReviewScore(review, counted)
+	if err != nil {
+		log.Printf("cannot restore rating of the laptop with id %v: %v", review.LaptopID, err)
+	}
+}
+
+// paginateReviews returns the page of reviews following the review of the
+// page token. The token keeps creation time and id of the last review, so
+// pages are not shifted by reviews added or moderated between requests.
+func paginateReviews(
+	reviews []*models.Review,
+	pageSize uint32,
+	pageToken string,
+	newestFirst bool,
+) ([]*models.Review, string, error) {
+	if pageSize == 0 {
+		pageSize = defaultReviewPageSize
+	}
+	pageSize = min(pageSize, maxReviewPageSize)
+
+	start := 0
+	if len(pageToken) > 0 {
+		createdAt, id, err := parseReviewPageToken(pageToken)
+		if err != nil {
+			return nil, "", status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+		}
+		for start < len(reviews) && !reviewFollows(reviews[start], createdAt, id, newestFirst) {
+			start++
+		}
+	}
+	end := min(start+int(pageSize), len(reviews))
+	page := reviews[start:end]
+	if end == len(reviews) {
+		return page, "", nil
+	}
+	last := page[len(page)-1]
+	return page, reviewPageToken(last.CreatedAt, last.ID), nil
+}
+
+func reviewFollows(review *models.Review, createdAt time.Time, id string, newestFirst bool) bool {
+	if !review.CreatedAt.Equal(createdAt) {
+		return review.CreatedAt.After(createdAt) != newestFirst
+	}
+	if review.ID == id {
+		return false
+	}
+	return (review.ID > id) != newestFirst
+}
+
+func reviewPageToken(createdAt time.Time, id string) string {
+	token := fmt.Sprintf("%d:%s", createdAt.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(token))
+}
+
+func parseReviewPageToken(pageToken string) (time.Time, string, error) {
+	token, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	nanos, id, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("malformed token")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, n), id, nil
+}
+
+func reviewsToPb(reviews []*models.Review) []*pb.Review {
+	res := make([]*pb.Review, 0, len(reviews))
+	for _, review := range reviews {
+		res = append(res, reviewToPb(review))
+	}
+	return res
+}
+
+func reviewToPb(review *models.Review) *pb.Review {
+	var reviewStatus pb.Review_Status
+	switch review.Status {
+	case models.ReviewPending:
+		reviewStatus = pb.Review_PENDING
+	case models.ReviewApproved:
+		reviewStatus = pb.Review_APPROVED
+	case models.ReviewRejected:
+		reviewStatus = pb.Review_REJECTED
+	case models.ReviewFlagged:
+		reviewStatus = pb.Review_FLAGGED
+	}
+	return &pb.Review{
+		Id:             review.ID,
+		LaptopId:       review.LaptopID,
+		Author:         review.Author,
+		Score:          review.Score,
+		Title:          review.Title,
+		Text:           review.Text,
+		Status:         reviewStatus,
+		CreatedAt:      timestamppb.New(review.CreatedAt),
+		UpdatedAt:      timestamppb.New(review.UpdatedAt),
+		ModeratedBy:    review.ModeratedBy,
+		ModerationNote: review.ModerationNote,
+	}
+}
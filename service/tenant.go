@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"main/models"
+	"main/pb"
+	"main/storage"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type TenantStorager interface {
+	Save(tenant *models.Tenant) error
+	Get(id string) (*models.Tenant, error)
+	List() ([]*models.Tenant, error)
+}
+
+type TenantServer struct {
+	tenantStorage TenantStorager
+	userStorage   UserStorager
+	pb.UnimplementedTenantServiceServer
+}
+
+func NewTenantServer(tenantStorage TenantStorager, userStorage UserStorager) *TenantServer {
+	return &TenantServer{
+		tenantStorage: tenantStorage,
+		userStorage:   userStorage,
+	}
+}
+
+func (ts *TenantServer) CreateTenant(ctx context.Context, req *pb.CreateTenantRequest) (*pb.CreateTenantResponse, error) {
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "tenant name is empty")
+	}
+	tenantID := req.GetId()
+	if len(tenantID) == 0 {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return nil, status.Error(codes.Internal, "cannot generate id")
+		}
+		tenantID = id.String()
+	}
+
+	tenant := &models.Tenant{
+		ID:        tenantID,
+		Name:      req.GetName(),
+		CreatedAt: time.Now(),
+	}
+	err := ts.tenantStorage.Save(tenant)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			code = codes.AlreadyExists
+		}
+		return nil, status.Errorf(code, "cannot save tenant: %v", err)
+	}
+	log.Printf("tenant %s created", tenant.ID)
+	return &pb.CreateTenantResponse{Tenant: tenantToPb(tenant)}, nil
+}
+
+func (ts *TenantServer) ListTenants(ctx context.Context, req *pb.ListTenantsRequest) (*pb.ListTenantsResponse, error) {
+	tenants, err := ts.tenantStorage.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list tenants: %v", err)
+	}
+
+	resp := &pb.ListTenantsResponse{
+		Tenants: make([]*pb.Tenant, 0, len(tenants)),
+	}
+	for _, tenant := range tenants {
+		resp.Tenants = append(resp.Tenants, tenantToPb(tenant))
+	}
+	return resp, nil
+}
+
+func (ts *TenantServer) AssignUser(ctx context.Context, req *pb.AssignUserRequest) (*pb.AssignUserResponse, error) {
+	tenant, err := ts.tenantStorage.Get(req.GetTenantId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find tenant: %v", err)
+	}
+	if tenant == nil {
+		return nil, status.Errorf(codes.NotFound, "tenant %v is not found", req.GetTenantId())
+	}
+
+	user, err := ts.userStorage.Get(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user %v is not found", req.GetUsername())
+	}
+
+	user.TenantID = tenant.ID
+	err = ts.userStorage.Update(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot update user: %v", err)
+	}
+	log.Printf("user %s assigned to tenant %s", user.UserName, tenant.ID)
+	return &pb.AssignUserResponse{}, nil
+}
+
+func tenantToPb(tenant *models.Tenant) *pb.Tenant {
+	return &pb.Tenant{
+		Id:        tenant.ID,
+		Name:      tenant.Name,
+		CreatedAt: timestamppb.New(tenant.CreatedAt),
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const thumbnailJPEGQuality = 85
+
+// Thumbnailer scales images down to fit into squares of the configured sizes.
+// PNG thumbnails stay PNG to keep transparency, others are encoded as JPEG.
+type Thumbnailer struct {
+	sizes []uint32
+}
+
+func NewThumbnailer(sizes []uint32) (*Thumbnailer, error) {
+	thumbnailer := &Thumbnailer{}
+	for _, size := range sizes {
+		if size == 0 {
+			return nil, fmt.Errorf("thumbnail size must be positive")
+		}
+		thumbnailer.sizes = append(thumbnailer.sizes, size)
+	}
+	sort.Slice(thumbnailer.sizes, func(i, j int) bool {
+		return thumbnailer.sizes[i] < thumbnailer.sizes[j]
+	})
+	return thumbnailer, nil
+}
+
+func (t *Thumbnailer) Sizes() []uint32 {
+	return t.sizes
+}
+
+func (t *Thumbnailer) HasSize(size uint32) bool {
+	for _, s := range t.sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns thumbnails of the image for every size, keyed by size,
+// and extension of the thumbnails. Images are never scaled up.
+func (t *Thumbnailer) Generate(imageData io.Reader) (map[uint32]*bytes.Buffer, string, error) {
+	img, format, err := image.Decode(imageData)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	ext := ".jpg"
+	if format == ImageFormatPNG {
+		ext = ".png"
+	}
+	res := make(map[uint32]*bytes.Buffer, len(t.sizes))
+	for _, size := range t.sizes {
+		thumbnail := scaleToFit(img, int(size))
+		buf := &bytes.Buffer{}
+		if ext == ".png" {
+			err = png.Encode(buf, thumbnail)
+		} else {
+			err = jpeg.Encode(buf, thumbnail, &jpeg.Options{Quality: thumbnailJPEGQuality})
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot encode %d thumbnail: %w", size, err)
+		}
+		res[size] = buf
+	}
+	return res, ext, nil
+}
+
+func scaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
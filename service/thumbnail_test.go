@@ -0,0 +1,37 @@
+package service_test
+
+import (
+	"bytes"
+	"image"
+	"main/service"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbnailerGenerate(t *testing.T) {
+	t.Parallel()
+
+	thumbnailer, err := service.NewThumbnailer([]uint32{8, 32, 400})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{8, 32, 400}, thumbnailer.Sizes())
+
+	thumbnails, ext, err := thumbnailer.Generate(bytes.NewReader(encodeTestPNG(t, 200, 50)))
+	require.NoError(t, err)
+	require.Equal(t, ".png", ext)
+
+	expected := map[uint32]image.Point{
+		8:   {X: 8, Y: 2},
+		32:  {X: 32, Y: 8},
+		400: {X: 200, Y: 50},
+	}
+	for size, point := range expected {
+		config, format, err := image.DecodeConfig(bytes.NewReader(thumbnails[size].Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, "png", format)
+		require.Equal(t, point, image.Point{X: config.Width, Y: config.Height})
+	}
+
+	_, err = service.NewThumbnailer([]uint32{0})
+	require.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"main/pb"
+	"main/storage"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultUploadTTL         = 24 * time.Hour
+	defaultMaxUploadsPerUser = 10
+)
+
+type UploadStorager interface {
+	Create(session *storage.UploadSession) error
+	Get(id string) (*storage.UploadSession, error)
+	PendingBytes(tenantID string, createdBy string, exceptID string) (int64, error)
+	Append(id string, offset int64, data []byte) (int64, error)
+	Remove(id string) error
+}
+
+func (s *LaptopServer) InitiateUpload(
+	ctx context.Context,
+	req *pb.InitiateUploadRequest,
+) (*pb.InitiateUploadResponse, error) {
+	byteSize := req.GetByteSize()
+	if byteSize == 0 {
+		return nil, status.Error(codes.InvalidArgument, "image size is empty")
+	}
+	session, err := s.createUploadSession(ctx, req.GetInfo(), int64(byteSize))
+	if err != nil {
+		return nil, err
+	}
+	// declared size is checked before any data is sent
+	err = s.checkImageSize(ctx, session.ByteSize)
+	if err == nil {
+		err = s.checkStorageQuota(ctx, session, session.ByteSize)
+	}
+	if err != nil {
+		s.UploadStorage.Remove(session.ID)
+		return nil, err
+	}
+	log.Printf("upload %v of image of laptop with id %v initiated", session.ID, session.LaptopID)
+	return &pb.InitiateUploadResponse{UploadId: session.ID}, nil
+}
+
+func (s *LaptopServer) QueryUpload(
+	ctx context.Context,
+	req *pb.QueryUploadRequest,
+) (*pb.QueryUploadResponse, error) {
+	session, err := s.getUploadSession(ctx, req.GetUploadId())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.QueryUploadResponse{
+		UploadId:        session.ID,
+		CommittedOffset: uint64(session.Offset),
+		ByteSize:        uint64(session.ByteSize),
+	}, nil
+}
+
+func (s *LaptopServer) createUploadSession(
+	ctx context.Context,
+	info *pb.ImageInfo,
+	byteSize int64,
+) (*storage.UploadSession, error) {
+	laptopId := info.GetLaptopId()
+	tenantID := tenantFromContext(ctx)
+	laptop, err := s.LaptopStorage.Get(tenantID, laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get laptop with id %v: %v", laptopId, err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop with id: %v does not exists", laptopId)
+	}
+	err = checkOwnership(ctx, laptop)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkImageCount(ctx, tenantID, laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "cannot generate id")
+	}
+	session := &storage.UploadSession{
+		ID:        id.String(),
+		TenantID:  tenantID,
+		LaptopID:  laptopId,
+		ImageType: info.GetImageType(),
+		Checksum:  info.GetChecksum(),
+		CreatedBy: usernameFromContext(ctx),
+		ByteSize:  byteSize,
+	}
+	err = s.UploadStorage.Create(session)
+	if errors.Is(err, storage.ErrTooManyUploads) {
+		return nil, quotaExceeded(fmt.Sprintf("user:%s", session.CreatedBy), err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create upload session: %v", err)
+	}
+	return session, nil
+}
+
+// getUploadSession returns session created by the same principal
+// in the same tenant, sessions of others are not found.
+func (s *LaptopServer) getUploadSession(ctx context.Context, id string) (*storage.UploadSession, error) {
+	session, err := s.UploadStorage.Get(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get upload session %v: %v", id, err)
+	}
+	if session == nil ||
+		session.TenantID != tenantFromContext(ctx) ||
+		session.CreatedBy != usernameFromContext(ctx) {
+		return nil, status.Errorf(codes.NotFound, "upload session %v is not found", id)
+	}
+	return session, nil
+}
+
+// appendChunk writes data of the request to the session. Data of single
+// stream upload is appended, chunks are written at their offsets.
+func (s *LaptopServer) appendChunk(ctx context.Context, session *storage.UploadSession, req *pb.UploadImageRequest) error {
+	var offset int64
+	var data []byte
+	switch req.GetData().(type) {
+	case *pb.UploadImageRequest_ChunkData:
+		if session.ByteSize > 0 {
+			return status.Error(codes.InvalidArgument, "chunk of upload session must have offset")
+		}
+		current, err := s.UploadStorage.Get(session.ID)
+		if err != nil || current == nil {
+			return status.Errorf(codes.Internal, "cannot get upload session: %v", err)
+		}
+		offset = current.Offset
+		data = req.GetChunkData()
+	case *pb.UploadImageRequest_Chunk:
+		chunk := req.GetChunk()
+		if chunk.GetUploadId() != session.ID {
+			return status.Errorf(codes.InvalidArgument, "chunk of another upload %v", chunk.GetUploadId())
+		}
+		offset = int64(chunk.GetOffset())
+		data = chunk.GetData()
+	default:
+		return status.Error(codes.InvalidArgument, "image info is expected only in the first request")
+	}
+
+	end := offset + int64(len(data))
+	err := s.checkImageSize(ctx, end)
+	if err != nil {
+		return err
+	}
+	if session.ByteSize > 0 && end > session.ByteSize {
+		return status.Errorf(codes.InvalidArgument, "chunk exceeds image size: %d > %d", end, session.ByteSize)
+	}
+	_, err = s.UploadStorage.Append(session.ID, offset, data)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, storage.ErrInvalidOffset) {
+			code = codes.OutOfRange
+		}
+		return status.Errorf(code, "cannot write chunk at offset %d: %v", offset, err)
+	}
+	return nil
+}
+
+// finishUpload validates and saves the uploaded image and removes the session.
+// The image is streamed from the session file, so it is never held in memory.
+func (s *LaptopServer) finishUpload(ctx context.Context, session *storage.UploadSession) (*pb.UploadImageResponse, error) {
+	file, err := os.Open(session.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot open uploaded image: %v", err)
+	}
+	defer func() {
+		file.Close()
+		err := s.UploadStorage.Remove(session.ID)
+		if err != nil {
+			log.Printf("cannot remove upload session %v: %v", session.ID, err)
+		}
+	}()
+
+	imageExt, err := s.ImageValidator.Validate(file, session.ImageType)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image: %v", err)
+	}
+	err = rewind(file)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot read uploaded image: %v", err)
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if len(session.Checksum) > 0 && !strings.EqualFold(session.Checksum, digest) {
+		return nil, status.Errorf(codes.DataLoss, "image checksum mismatch: %v != %v", session.Checksum, digest)
+	}
+	// concurrent uploads may have used the quota since the session was created
+	err = s.checkImageCount(ctx, session.TenantID, session.LaptopID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkStorageQuota(ctx, session, size)
+	if err != nil {
+		return nil, err
+	}
+	err = rewind(file)
+	if err != nil {
+		return nil, err
+	}
+	imageId, err := s.ImageStorage.Save(ctx, session.TenantID, session.LaptopID, imageExt, session.CreatedBy, file, size, digest)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save image: %v", err)
+	}
+	err = rewind(file)
+	if err != nil {
+		return nil, err
+	}
+	s.saveThumbnails(ctx, session.TenantID, imageId, file)
+
+	return &pb.UploadImageResponse{
+		Id:       imageId,
+		ByteSize: uint32(size),
+		Digest:   digest,
+	}, nil
+}
+
+func rewind(file *os.File) error {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot read uploaded image: %v", err)
+	}
+	return nil
+}
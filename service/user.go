@@ -6,5 +6,6 @@ import (
 
 type UserStorager interface {
 	Save(user *models.User) error
+	Update(user *models.User) error
 	Get(username string) (*models.User, error)
 }
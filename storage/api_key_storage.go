@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"main/models"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type APIKeyStorage struct {
+	mu      sync.RWMutex
+	keys    map[string]*models.APIKey
+	byHash  map[string]string
+	journal *journal
+}
+
+func NewAPIKeyStorage() *APIKeyStorage {
+	return &APIKeyStorage{
+		keys:   make(map[string]*models.APIKey),
+		byHash: make(map[string]string),
+	}
+}
+
+// OpenAPIKeyStorage restores API keys from the journal in the data folder.
+// Every saved or revoked key is written to the journal, the last record
+// wins. Only hashes of the keys are written.
+func OpenAPIKeyStorage(dataDir string) (*APIKeyStorage, error) {
+	s := NewAPIKeyStorage()
+	journal, err := openJournal(filepath.Join(dataDir, "api_keys.jsonl"), func(data []byte) error {
+		key := &models.APIKey{}
+		err := json.Unmarshal(data, key)
+		if err != nil {
+			return err
+		}
+		s.keys[key.ID] = key
+		s.byHash[key.HashedKey] = key.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Check returns an error if API keys cannot be written to the journal.
+func (s *APIKeyStorage) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.journal.check()
+}
+
+func (s *APIKeyStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.close()
+}
+
+func (s *APIKeyStorage) Save(key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.ID]; ok {
+		return ErrAlreadyExist
+	}
+	if _, ok := s.byHash[key.HashedKey]; ok {
+		return ErrAlreadyExist
+	}
+
+	return s.put(key)
+}
+
+func (s *APIKeyStorage) Get(id string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	return key.Clone(), nil
+}
+
+func (s *APIKeyStorage) GetByHash(hashedKey string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byHash[hashedKey]
+	if !ok {
+		return nil, nil
+	}
+	return s.keys[id].Clone(), nil
+}
+
+func (s *APIKeyStorage) List() ([]*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*models.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key.Clone())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *APIKeyStorage) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	revoked := key.Clone()
+	revoked.Revoked = true
+	return s.put(revoked)
+}
+
+// put must be called with the lock held.
+func (s *APIKeyStorage) put(key *models.APIKey) error {
+	if s.journal != nil {
+		err := s.journal.append(key)
+		if err != nil {
+			return err
+		}
+	}
+	s.keys[key.ID] = key.Clone()
+	s.byHash[key.HashedKey] = key.ID
+	return nil
+}
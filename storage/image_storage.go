@@ -2,56 +2,568 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"main/blob"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrInvalidOrder = errors.New("image ids must list every image of the laptop once")
+)
+
+// ImageStorage keeps metadata of images, their content is kept in the blob
+// store by SHA-256 of the content, so the same image uploaded for many laptops
+// is stored once. Every laptop references the content at most once.
+//
+// The blob store is not accessed with the lock held, so slow uploads do not
+// block other requests. Instead the content is reserved by its digest for
+// the time of the blob I/O, changes of other content are not blocked.
 type ImageStorage struct {
-	mu          sync.Mutex
-	imageFolder string
-	images      map[string]*ImageInfo
+	mu     sync.RWMutex
+	store  blob.Store
+	images map[string]*ImageInfo
+	blobs  map[string]*imageBlob
+	// reserved keeps digests of the content with blob I/O in progress
+	reserved map[string]bool
+	// released is signalled when the content is released
+	released *sync.Cond
+	// primary keeps explicitly chosen primary image of the laptop
+	primary map[string]string
+	// indexPath is the file of the persisted index, empty if it is not persisted
+	indexPath string
+	// persistErr is the error of the last write of the index
+	persistErr error
+}
+
+// imageIndex is the persisted metadata of images.
+type imageIndex struct {
+	Images []*ImageInfo `json:"images"`
+	// Thumbnails are shared by images with the same digest.
+	Thumbnails map[string][]*Thumbnail `json:"thumbnails"`
+	Primary    map[string]string       `json:"primary"`
 }
 
 type ImageInfo struct {
-	LaptopID string
-	Type     string
-	Path     string
+	ID         string
+	TenantID   string
+	LaptopID   string
+	Type       string
+	Key        string
+	Size       int64
+	Digest     string
+	UploadedBy string
+	UploadedAt time.Time
+	// Position is the place of the image in the display order of the laptop.
+	Position   int
+	Thumbnails []*Thumbnail
+}
+
+// Thumbnail is a scaled down variant of the image which fits
+// into a square of the given size.
+type Thumbnail struct {
+	Size uint32
+	Type string
+	Key  string
+	// ByteSize is the size of the thumbnail file.
+	ByteSize int64
+}
+
+type imageBlob struct {
+	refs       int
+	thumbnails []*Thumbnail
+}
+
+func (info *ImageInfo) Clone() *ImageInfo {
+	other := *info
+	other.Thumbnails = make([]*Thumbnail, 0, len(info.Thumbnails))
+	for _, thumbnail := range info.Thumbnails {
+		t := *thumbnail
+		other.Thumbnails = append(other.Thumbnails, &t)
+	}
+	return &other
+}
+
+// Thumbnail returns the variant of the given size or nil.
+func (info *ImageInfo) Thumbnail(size uint32) *Thumbnail {
+	for _, thumbnail := range info.Thumbnails {
+		if thumbnail.Size == size {
+			return thumbnail
+		}
+	}
+	return nil
 }
 
-func NewImageStorage(imageFolder string) *ImageStorage {
-	return &ImageStorage{
-		imageFolder: imageFolder,
-		images:      make(map[string]*ImageInfo),
+func NewImageStorage(store blob.Store) *ImageStorage {
+	storage := &ImageStorage{
+		store:    store,
+		images:   make(map[string]*ImageInfo),
+		blobs:    make(map[string]*imageBlob),
+		reserved: make(map[string]bool),
+		primary:  make(map[string]string),
+	}
+	storage.released = sync.NewCond(&storage.mu)
+	return storage
+}
+
+// OpenImageStorage restores metadata of images from the index in the data
+// folder. The index is rewritten after every change, if it cannot be written
+// the change stays in memory only and the error is returned.
+func OpenImageStorage(store blob.Store, dataDir string) (*ImageStorage, error) {
+	storage := NewImageStorage(store)
+	storage.indexPath = filepath.Join(dataDir, "images.json")
+	index := &imageIndex{}
+	err := readJSONFile(storage.indexPath, index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image index: %w", err)
 	}
+	for _, info := range index.Images {
+		info.Thumbnails = nil
+		storage.images[info.ID] = info
+		content, ok := storage.blobs[info.Digest]
+		if !ok {
+			content = &imageBlob{thumbnails: index.Thumbnails[info.Digest]}
+			storage.blobs[info.Digest] = content
+		}
+		content.refs++
+	}
+	for key, id := range index.Primary {
+		storage.primary[key] = id
+	}
+	return storage, nil
 }
 
+// Save streams the image of the given size and SHA-256 digest in hex to
+// the blob store. It returns id of the existing image if the laptop already
+// has an image with the same content.
 func (storage *ImageStorage) Save(
+	ctx context.Context,
+	tenantID string,
 	laptopID string,
 	imageType string,
-	imageData bytes.Buffer,
+	uploadedBy string,
+	imageData io.Reader,
+	size int64,
+	digest string,
 ) (string, error) {
 	imageID, err := uuid.NewRandom()
 	if err != nil {
 		return "", fmt.Errorf("cannot generate id: (%w)", err)
 	}
-	imagePath := fmt.Sprintf("%s/%s%s", storage.imageFolder, imageID, imageType)
-	file, err := os.Create(imagePath)
-	if err != nil {
-		return "", fmt.Errorf("cannot create image file: (%w)", err)
+	imageKey := fmt.Sprintf("%s%s", digest, imageType)
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.reserve(digest)
+	defer storage.release(digest)
+
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.LaptopID == laptopID && info.Digest == digest {
+			return info.ID, nil
+		}
+	}
+	content, ok := storage.blobs[digest]
+	if !ok {
+		storage.mu.Unlock()
+		err = storage.store.Put(ctx, imageKey, imageData, size)
+		if err != nil {
+			// partially written content is not referenced by anything
+			storage.store.Delete(context.WithoutCancel(ctx), imageKey)
+		}
+		storage.mu.Lock()
+		if err != nil {
+			return "", fmt.Errorf("cannot write image data: (%w)", err)
+		}
+		content = &imageBlob{}
+		storage.blobs[digest] = content
 	}
+	content.refs++
 
-	if _, err = imageData.WriteTo(file); err != nil {
-		return "", fmt.Errorf("cannot write image data to file: (%w)", err)
+	// the position is found after the upload, since other images
+	// of the laptop may be saved meanwhile
+	position := 0
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.LaptopID == laptopID {
+			position = max(position, info.Position+1)
+		}
 	}
+
+	storage.images[imageID.String()] = &ImageInfo{
+		ID:         imageID.String(),
+		TenantID:   tenantID,
+		LaptopID:   laptopID,
+		Type:       imageType,
+		Key:        imageKey,
+		Size:       size,
+		Digest:     digest,
+		UploadedBy: uploadedBy,
+		UploadedAt: time.Now(),
+		Position:   position,
+	}
+	err = storage.persist()
+	if err != nil {
+		return "", err
+	}
+	return imageID.String(), nil
+}
+
+// SaveThumbnail stores the thumbnail next to the original image.
+// Thumbnails are shared by images with the same content.
+func (storage *ImageStorage) SaveThumbnail(
+	ctx context.Context,
+	tenantID string,
+	imageID string,
+	size uint32,
+	imageType string,
+	imageData bytes.Buffer,
+) error {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
-	storage.images[imageID.String()] = &ImageInfo{
-		LaptopID: laptopID,
+
+	info, ok := storage.images[imageID]
+	if !ok || info.TenantID != tenantID {
+		return ErrNotFound
+	}
+	digest := info.Digest
+	storage.reserve(digest)
+	defer storage.release(digest)
+
+	// the image may be deleted while the content is reserved by others
+	if _, ok := storage.images[imageID]; !ok {
+		return ErrNotFound
+	}
+	content := storage.blobs[digest]
+	for _, thumbnail := range content.thumbnails {
+		if thumbnail.Size == size {
+			return nil
+		}
+	}
+
+	thumbnailKey := fmt.Sprintf("%s_%d%s", digest, size, imageType)
+	byteSize := int64(imageData.Len())
+	storage.mu.Unlock()
+	err := storage.store.Put(ctx, thumbnailKey, &imageData, byteSize)
+	if err != nil {
+		storage.store.Delete(context.WithoutCancel(ctx), thumbnailKey)
+	}
+	storage.mu.Lock()
+	if err != nil {
+		return fmt.Errorf("cannot write thumbnail data: (%w)", err)
+	}
+	content.thumbnails = append(content.thumbnails, &Thumbnail{
+		Size:     size,
 		Type:     imageType,
-		Path:     imagePath,
+		Key:      thumbnailKey,
+		ByteSize: byteSize,
+	})
+	return storage.persist()
+}
+
+func (storage *ImageStorage) Get(tenantID string, imageID string) (*ImageInfo, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	info, ok := storage.images[imageID]
+	if !ok || info.TenantID != tenantID {
+		return nil, nil
 	}
-	return imageID.String(), nil
+	return storage.withThumbnails(info), nil
+}
+
+// List returns images of the laptop in display order.
+func (storage *ImageStorage) List(tenantID string, laptopID string) ([]*ImageInfo, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	res := make([]*ImageInfo, 0)
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.LaptopID == laptopID {
+			res = append(res, storage.withThumbnails(info))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Position < res[j].Position
+	})
+	return res, nil
+}
+
+// Usage returns number and total size of images uploaded by the user.
+func (storage *ImageStorage) Usage(tenantID string, uploadedBy string) (int, int64, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	count := 0
+	var size int64
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.UploadedBy == uploadedBy {
+			count++
+			size += info.Size
+		}
+	}
+	return count, size, nil
+}
+
+// Primary returns id of the primary image of the laptop, which is
+// the first image unless it is set explicitly, or empty string.
+func (storage *ImageStorage) Primary(tenantID string, laptopID string) (string, error) {
+	images, err := storage.List(tenantID, laptopID)
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", nil
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	if id, ok := storage.primary[laptopKey(tenantID, laptopID)]; ok {
+		return id, nil
+	}
+	return images[0].ID, nil
+}
+
+func (storage *ImageStorage) SetPrimary(tenantID string, laptopID string, imageID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	info, ok := storage.images[imageID]
+	if !ok || info.TenantID != tenantID || info.LaptopID != laptopID {
+		return ErrNotFound
+	}
+	storage.primary[laptopKey(tenantID, laptopID)] = imageID
+	return storage.persist()
+}
+
+// Reorder sets display order of images of the laptop. The ids must
+// list every image of the laptop exactly once.
+func (storage *ImageStorage) Reorder(tenantID string, laptopID string, imageIDs []string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	count := 0
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.LaptopID == laptopID {
+			count++
+		}
+	}
+	if len(imageIDs) != count {
+		return ErrInvalidOrder
+	}
+	seen := make(map[string]bool, len(imageIDs))
+	for _, id := range imageIDs {
+		info, ok := storage.images[id]
+		if !ok || info.TenantID != tenantID || info.LaptopID != laptopID || seen[id] {
+			return ErrInvalidOrder
+		}
+		seen[id] = true
+	}
+	for position, id := range imageIDs {
+		storage.images[id].Position = position
+	}
+	return storage.persist()
+}
+
+// Delete removes the image. Content of the image and its thumbnails
+// is removed from the blob store when no images reference it.
+func (storage *ImageStorage) Delete(ctx context.Context, tenantID string, imageID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	info, ok := storage.images[imageID]
+	if !ok || info.TenantID != tenantID {
+		return ErrNotFound
+	}
+	err := storage.delete(ctx, info)
+	if err != nil {
+		return err
+	}
+	return storage.persist()
+}
+
+// DeleteByLaptop removes all images of the laptop.
+func (storage *ImageStorage) DeleteByLaptop(ctx context.Context, tenantID string, laptopID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	images := make([]*ImageInfo, 0)
+	for _, info := range storage.images {
+		if info.TenantID == tenantID && info.LaptopID == laptopID {
+			images = append(images, info)
+		}
+	}
+	for _, info := range images {
+		// images deleted meanwhile are skipped
+		err := storage.delete(ctx, info)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	delete(storage.primary, laptopKey(tenantID, laptopID))
+	return storage.persist()
+}
+
+// delete removes data before metadata, so failed deletion can be
+// repeated. It returns ErrNotFound if the image was deleted while
+// waiting for the content. Must be called with the lock held.
+func (storage *ImageStorage) delete(ctx context.Context, info *ImageInfo) error {
+	storage.reserve(info.Digest)
+	defer storage.release(info.Digest)
+
+	if _, ok := storage.images[info.ID]; !ok {
+		return ErrNotFound
+	}
+	content := storage.blobs[info.Digest]
+	if content.refs == 1 {
+		keys := []string{info.Key}
+		for _, thumbnail := range content.thumbnails {
+			keys = append(keys, thumbnail.Key)
+		}
+		storage.mu.Unlock()
+		err := storage.deleteBlobs(ctx, keys)
+		storage.mu.Lock()
+		if err != nil {
+			return err
+		}
+		delete(storage.blobs, info.Digest)
+	} else {
+		content.refs--
+	}
+
+	delete(storage.images, info.ID)
+	key := laptopKey(info.TenantID, info.LaptopID)
+	if storage.primary[key] == info.ID {
+		delete(storage.primary, key)
+	}
+	return nil
+}
+
+func (storage *ImageStorage) deleteBlobs(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		err := storage.store.Delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("cannot delete image data: (%w)", err)
+		}
+	}
+	return nil
+}
+
+// reserve waits until blob I/O of other requests with the content is
+// finished and reserves the content. The lock is released while waiting,
+// so the state must be checked again after it. Must be called with the
+// lock held.
+func (storage *ImageStorage) reserve(digest string) {
+	for storage.reserved[digest] {
+		storage.released.Wait()
+	}
+	storage.reserved[digest] = true
+}
+
+// release must be called with the lock held.
+func (storage *ImageStorage) release(digest string) {
+	delete(storage.reserved, digest)
+	storage.released.Broadcast()
+}
+
+// Open opens the original image if size is 0 and its thumbnail otherwise.
+func (storage *ImageStorage) Open(ctx context.Context, tenantID string, imageID string, size uint32) (io.ReadCloser, error) {
+	info, err := storage.Get(tenantID, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, ErrNotFound
+	}
+	key := info.Key
+	if size > 0 {
+		thumbnail := info.Thumbnail(size)
+		if thumbnail == nil {
+			return nil, ErrNotFound
+		}
+		key = thumbnail.Key
+	}
+	reader, err := storage.store.Get(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, fmt.Errorf("image data is missing: (%w)", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open image data: (%w)", err)
+	}
+	return reader, nil
+}
+
+// Check returns an error if the blob store is not available
+// or the last change of the index was not written.
+func (storage *ImageStorage) Check(ctx context.Context) error {
+	storage.mu.RLock()
+	persistErr := storage.persistErr
+	storage.mu.RUnlock()
+
+	if persistErr != nil {
+		return fmt.Errorf("cannot write image index: %w", persistErr)
+	}
+	return storage.store.Ping(ctx)
+}
+
+// RefCount returns number of images referencing the content with the digest.
+func (storage *ImageStorage) RefCount(digest string) int {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	content, ok := storage.blobs[digest]
+	if !ok {
+		return 0
+	}
+	return content.refs
+}
+
+func (storage *ImageStorage) withThumbnails(info *ImageInfo) *ImageInfo {
+	other := info.Clone()
+	if content, ok := storage.blobs[info.Digest]; ok {
+		for _, thumbnail := range content.thumbnails {
+			t := *thumbnail
+			other.Thumbnails = append(other.Thumbnails, &t)
+		}
+	}
+	return other
+}
+
+// persist writes the index if it is persisted. Must be called with the lock held.
+func (storage *ImageStorage) persist() error {
+	if len(storage.indexPath) == 0 {
+		return nil
+	}
+	index := &imageIndex{
+		Images:     make([]*ImageInfo, 0, len(storage.images)),
+		Thumbnails: make(map[string][]*Thumbnail),
+		Primary:    storage.primary,
+	}
+	for _, info := range storage.images {
+		index.Images = append(index.Images, info)
+	}
+	sort.Slice(index.Images, func(i, j int) bool {
+		return index.Images[i].ID < index.Images[j].ID
+	})
+	for digest, content := range storage.blobs {
+		if len(content.thumbnails) > 0 {
+			index.Thumbnails[digest] = content.thumbnails
+		}
+	}
+	err := writeJSONFile(storage.indexPath, index)
+	storage.persistErr = err
+	if err != nil {
+		return fmt.Errorf("cannot write image index: %w", err)
+	}
+	return nil
+}
+
+func laptopKey(tenantID string, laptopID string) string {
+	return tenantID + "/" + laptopID
 }
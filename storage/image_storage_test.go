@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"main/blob"
+	"main/storage"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore holds puts of the key until they are released.
+type blockingStore struct {
+	blob.Store
+	key     string
+	started chan struct{}
+	release chan error
+}
+
+func (s *blockingStore) Put(ctx context.Context, key string, data io.Reader, size int64) error {
+	if key == s.key {
+		s.started <- struct{}{}
+		err := <-s.release
+		if err != nil {
+			return err
+		}
+	}
+	return s.Store.Put(ctx, key, data, size)
+}
+
+func TestImageStorageSaveDoesNotBlock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte("slow"))
+	digest := hex.EncodeToString(sum[:])
+	store := &blockingStore{
+		Store:   blob.NewMemoryStore(),
+		key:     digest + ".png",
+		started: make(chan struct{}),
+		release: make(chan error),
+	}
+	images := storage.NewImageStorage(store)
+	saveSlow := func() <-chan error {
+		saveErr := make(chan error, 1)
+		go func() {
+			_, err := images.Save(ctx, "tenant1", "laptop1", ".png", "user1", strings.NewReader("slow"), 4, digest)
+			saveErr <- err
+		}()
+		<-store.started
+		return saveErr
+	}
+
+	// other images are saved and listed while the slow upload is in progress
+	saveErr := saveSlow()
+	id := saveTestImage(t, images, "laptop1", "fast")
+	list, err := images.List("tenant1", "laptop1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, id, list[0].ID)
+
+	// failed upload leaves no metadata behind
+	store.release <- errors.New("store is unavailable")
+	require.Error(t, <-saveErr)
+	require.Equal(t, 0, images.RefCount(digest))
+
+	saveErr = saveSlow()
+	store.release <- nil
+	require.NoError(t, <-saveErr)
+	require.Equal(t, 1, images.RefCount(digest))
+	list, err = images.List("tenant1", "laptop1")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, 1, list[1].Position)
+}
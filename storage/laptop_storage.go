@@ -13,23 +13,26 @@ import (
 
 var (
 	ErrAlreadyExist = errors.New("laptop with this id already exist")
+	ErrNotFound     = errors.New("not found")
 )
 
+// InMemoryLaptopStore keeps laptops of every tenant separately,
+// so laptop of one tenant is never visible to another.
 type InMemoryLaptopStore struct {
 	mu   sync.RWMutex
-	data map[string]*pb.Laptop
+	data map[string]map[string]*pb.Laptop
 }
 
 func NewInMemoryLaptopStorage() *InMemoryLaptopStore {
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data: make(map[string]map[string]*pb.Laptop),
 	}
 }
 
-func (m *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
+func (m *InMemoryLaptopStore) Save(tenantID string, laptop *pb.Laptop) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if _, ok := m.data[laptop.Id]; ok {
+	if _, ok := m.data[tenantID][laptop.Id]; ok {
 		return ErrAlreadyExist
 	}
 
@@ -39,15 +42,46 @@ func (m *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 		return fmt.Errorf("cannot copy laptop data: %w", err)
 	}
 
-	m.data[other.Id] = other
+	if m.data[tenantID] == nil {
+		m.data[tenantID] = make(map[string]*pb.Laptop)
+	}
+	m.data[tenantID][other.Id] = other
+	return nil
+}
+
+func (m *InMemoryLaptopStore) Update(tenantID string, laptop *pb.Laptop) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[tenantID][laptop.Id]; !ok {
+		return ErrNotFound
+	}
+
+	other := &pb.Laptop{}
+	err := copier.Copy(other, laptop)
+	if err != nil {
+		return fmt.Errorf("cannot copy laptop data: %w", err)
+	}
+
+	m.data[tenantID][other.Id] = other
+	return nil
+}
+
+func (m *InMemoryLaptopStore) Delete(tenantID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[tenantID][id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.data[tenantID], id)
 	return nil
 }
 
-func (m *InMemoryLaptopStore) Get(id string) (*pb.Laptop, error) {
+func (m *InMemoryLaptopStore) Get(tenantID string, id string) (*pb.Laptop, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	laptop, ok := m.data[id]
+	laptop, ok := m.data[tenantID][id]
 	if !ok {
 		return nil, nil
 	}
@@ -61,13 +95,14 @@ func (m *InMemoryLaptopStore) Get(id string) (*pb.Laptop, error) {
 
 func (m *InMemoryLaptopStore) Search(
 	ctx context.Context,
+	tenantID string,
 	filter *pb.Filter,
 	found func(laptop *pb.Laptop) error,
 ) error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	for _, laptop := range m.data {
+	for _, laptop := range m.data[tenantID] {
 		if err := ctx.Err(); err == context.Canceled || err == context.DeadlineExceeded {
 			log.Println("context is cancelled")
 			return errors.New("context is cancelled")
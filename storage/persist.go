@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	errJournalClosed = errors.New("journal is closed")
+)
+
+// journal is the append-only file of JSON records, one per line. Every
+// record is synced to disk before the change is applied in memory. After
+// failed write the journal rejects records, since the file may end with
+// a partial record.
+type journal struct {
+	file *os.File
+	err  error
+}
+
+// openJournal calls load for every record of the journal at path and opens
+// it for appending. Incomplete last record left by a crash is dropped.
+func openJournal(path string, load func(data []byte) error) (*journal, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create data folder: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open journal: %w", err)
+	}
+
+	var size int64
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cannot read journal: %w", err)
+		}
+		size += int64(len(data))
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		err = load(data)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("invalid record %v of journal %v: %w", line, path, err)
+		}
+	}
+	err = file.Truncate(size)
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot open journal for appending: %w", err)
+	}
+	return &journal{file: file}, nil
+}
+
+func (j *journal) append(record any) error {
+	if j.err != nil {
+		return j.err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot marshal journal record: %w", err)
+	}
+	_, err = j.file.Write(append(data, '\n'))
+	if err != nil {
+		j.err = fmt.Errorf("cannot write journal record: %w", err)
+		return j.err
+	}
+	err = j.file.Sync()
+	if err != nil {
+		j.err = fmt.Errorf("cannot sync journal: %w", err)
+		return j.err
+	}
+	return nil
+}
+
+// rewrite replaces the journal with the records, so removed data does not
+// stay on disk. The records are written to a temporary file first, which
+// replaces the journal only when it is complete.
+func (j *journal) rewrite(records []any) error {
+	if j.err != nil {
+		return j.err
+	}
+	path := j.file.Name()
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create journal: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		var data []byte
+		data, err = json.Marshal(record)
+		if err != nil {
+			err = fmt.Errorf("cannot marshal journal record: %w", err)
+			break
+		}
+		_, err = writer.Write(append(data, '\n'))
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot rewrite journal: %w", err)
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// check returns the error which made the journal unusable.
+func (j *journal) check() error {
+	if j == nil {
+		return nil
+	}
+	return j.err
+}
+
+func (j *journal) close() error {
+	if j == nil || j.err == errJournalClosed {
+		return nil
+	}
+	j.err = errJournalClosed
+	return j.file.Close()
+}
+
+// readJSONFile decodes the file at path into v, missing file is not an error.
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON. The data is
+// written to a temporary file first, so the file is never partially written.
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,325 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"main/blob"
+	"main/models"
+	"main/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	ratings, err := storage.OpenRatingStorage(dataDir)
+	require.NoError(t, err)
+	_, err = ratings.Rate("tenant1", "laptop1", "user1", 8)
+	require.NoError(t, err)
+	_, err = ratings.Rate("tenant1", "laptop1", "user2", 6)
+	require.NoError(t, err)
+	_, err = ratings.Rate("tenant1", "laptop1", "user1", 10)
+	require.NoError(t, err)
+	_, err = ratings.Delete("tenant1", "laptop1", "user2")
+	require.NoError(t, err)
+	_, err = ratings.Delete("tenant1", "laptop1", "user3")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, ratings.Check(context.Background()))
+	require.NoError(t, ratings.Close())
+	require.Error(t, ratings.Check(context.Background()))
+	_, err = ratings.Rate("tenant1", "laptop1", "user3", 1)
+	require.Error(t, err)
+
+	// incomplete record of a crash is dropped
+	journal := filepath.Join(dataDir, "ratings.jsonl")
+	file, err := os.OpenFile(journal, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"tenant_id":"tenant1","laptop_id":"lap`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	ratings, err = storage.OpenRatingStorage(dataDir)
+	require.NoError(t, err)
+	scores, err := ratings.Scores("tenant1", "laptop1")
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 10}}, scores)
+	events, err := ratings.Events("tenant1", "laptop1", time.Now())
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	require.True(t, events[3].Deleted)
+
+	_, err = ratings.Rate("tenant1", "laptop2", "user1", 5)
+	require.NoError(t, err)
+	require.NoError(t, ratings.Close())
+	ratings, err = storage.OpenRatingStorage(dataDir)
+	require.NoError(t, err)
+	defer ratings.Close()
+	all, err := ratings.Ratings("tenant1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]*storage.Rating{
+		"laptop1": {Count: 1, Sum: 10},
+		"laptop2": {Count: 1, Sum: 5},
+	}, all)
+}
+
+func TestRatingStorageClockStepsBack(t *testing.T) {
+	t.Parallel()
+
+	ratings := storage.NewRatingStorage()
+	future := time.Now().Add(time.Hour)
+	_, err := ratings.Apply("tenant1", storage.RatingEvent{LaptopID: "laptop1", RatedBy: "user1", Score: 8, Time: future})
+	require.NoError(t, err)
+
+	// the clock is behind the last event, so the new event gets its time
+	rating, err := ratings.Rate("tenant1", "laptop1", "user2", 6)
+	require.NoError(t, err)
+	require.Equal(t, &storage.Rating{Count: 2, Sum: 14}, rating)
+	events, err := ratings.Events("tenant1", "laptop1", future.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, future, events[1].Time)
+
+	_, err = ratings.Apply("tenant1", storage.RatingEvent{LaptopID: "laptop1", RatedBy: "user3", Score: 5, Time: time.Now()})
+	require.ErrorIs(t, err, storage.ErrEventOutOfOrder)
+}
+
+func TestUserStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	users, err := storage.OpenUserStorage(dataDir)
+	require.NoError(t, err)
+	user := &models.User{UserName: "user1", HashedPassword: "hash1", Role: "user", TenantID: models.DefaultTenantID}
+	require.NoError(t, users.Save(user))
+	require.ErrorIs(t, users.Save(user), storage.ErrAlreadyExist)
+	user.HashedPassword = "hash2"
+	require.NoError(t, users.Update(user))
+	require.ErrorIs(t, users.Update(&models.User{UserName: "user2"}), storage.ErrNotFound)
+	require.NoError(t, users.Close())
+
+	users, err = storage.OpenUserStorage(dataDir)
+	require.NoError(t, err)
+	defer users.Close()
+	restored, err := users.Get("user1")
+	require.NoError(t, err)
+	require.Equal(t, user, restored)
+	missing, err := users.Get("user2")
+	require.NoError(t, err)
+	require.Nil(t, missing)
+}
+
+func TestTenantStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	tenants, err := storage.OpenTenantStorage(dataDir)
+	require.NoError(t, err)
+	tenant := &models.Tenant{ID: "tenant1", Name: "Tenant", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	require.NoError(t, tenants.Save(tenant))
+	require.ErrorIs(t, tenants.Save(tenant), storage.ErrAlreadyExist)
+	require.NoError(t, tenants.Check(context.Background()))
+	require.NoError(t, tenants.Close())
+
+	tenants, err = storage.OpenTenantStorage(dataDir)
+	require.NoError(t, err)
+	defer tenants.Close()
+	all, err := tenants.List()
+	require.NoError(t, err)
+	require.Equal(t, []*models.Tenant{tenant}, all)
+}
+
+func TestReviewStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	reviews, err := storage.OpenReviewStorage(dataDir)
+	require.NoError(t, err)
+	review, err := models.NewReview(models.DefaultTenantID, "laptop1", "user1", 8, "Good", "Fast enough")
+	require.NoError(t, err)
+	review.CreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	review.UpdatedAt = review.CreatedAt
+	pending, err := models.NewReview(models.DefaultTenantID, "laptop1", "user2", 4, "Bad", "Battery died")
+	require.NoError(t, err)
+	pending.CreatedAt = review.CreatedAt.Add(time.Second)
+	pending.UpdatedAt = pending.CreatedAt
+	_, err = reviews.Save(review)
+	require.NoError(t, err)
+	_, err = reviews.Save(pending)
+	require.NoError(t, err)
+	approved, err := reviews.SetStatus(models.DefaultTenantID, review.ID, models.ReviewApproved, "admin1", "ok")
+	require.NoError(t, err)
+	_, err = reviews.SetStatus(models.DefaultTenantID, "unknown", models.ReviewApproved, "admin1", "")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, reviews.Check(context.Background()))
+	require.NoError(t, reviews.Close())
+
+	reviews, err = storage.OpenReviewStorage(dataDir)
+	require.NoError(t, err)
+	defer reviews.Close()
+	restored, err := reviews.Get(models.DefaultTenantID, review.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReviewApproved, restored.Status)
+	require.Equal(t, "admin1", restored.ModeratedBy)
+	require.True(t, approved.UpdatedAt.Equal(restored.UpdatedAt))
+	queue, err := reviews.List(models.DefaultTenantID, "", models.ReviewPending)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Review{pending}, queue)
+}
+
+func TestDeleteByLaptopRewritesJournals(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	ratings, err := storage.OpenRatingStorage(dataDir)
+	require.NoError(t, err)
+	reviews, err := storage.OpenReviewStorage(dataDir)
+	require.NoError(t, err)
+	for _, laptopID := range []string{"laptop1", "laptop2"} {
+		_, err = ratings.Rate("tenant1", laptopID, "user1", 8)
+		require.NoError(t, err)
+		_, err = ratings.RateReview("tenant1", laptopID, "user2", 6)
+		require.NoError(t, err)
+		review, err := models.NewReview("tenant1", laptopID, "user2", 6, "Fine", "Does the job")
+		require.NoError(t, err)
+		_, err = reviews.Save(review)
+		require.NoError(t, err)
+	}
+	_, err = ratings.Rate("tenant2", "laptop1", "user1", 4)
+	require.NoError(t, err)
+
+	require.NoError(t, ratings.DeleteByLaptop("tenant1", "laptop1"))
+	require.NoError(t, reviews.DeleteByLaptop("tenant1", "laptop1"))
+	require.NoError(t, ratings.DeleteByLaptop("tenant1", "unknown"))
+	// the journals are still open for appending
+	_, err = ratings.Rate("tenant1", "laptop2", "user3", 10)
+	require.NoError(t, err)
+	require.NoError(t, ratings.Close())
+	require.NoError(t, reviews.Close())
+
+	ratings, err = storage.OpenRatingStorage(dataDir)
+	require.NoError(t, err)
+	defer ratings.Close()
+	events, err := ratings.Events("tenant1", "laptop1", time.Now())
+	require.NoError(t, err)
+	require.Empty(t, events)
+	scores, err := ratings.Scores("tenant1", "laptop2")
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 8}, {RatedBy: "user2", Value: 6}, {RatedBy: "user3", Value: 10}}, scores)
+	scores, err = ratings.Scores("tenant2", "laptop1")
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+
+	reviews, err = storage.OpenReviewStorage(dataDir)
+	require.NoError(t, err)
+	defer reviews.Close()
+	list, err := reviews.List("tenant1", "", models.ReviewPending)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "laptop2", list[0].LaptopID)
+
+	for _, name := range []string{"ratings.jsonl", "reviews.jsonl"} {
+		data, err := os.ReadFile(filepath.Join(dataDir, name))
+		require.NoError(t, err)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			require.False(t, strings.Contains(line, `"tenant1"`) && strings.Contains(line, `"laptop1"`), line)
+		}
+	}
+}
+
+func TestAPIKeyStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	keys, err := storage.OpenAPIKeyStorage(dataDir)
+	require.NoError(t, err)
+	key, rawKey, err := models.NewAPIKey("ingestion", "admin", models.DefaultTenantID, []string{"laptop:create"}, 0)
+	require.NoError(t, err)
+	key.CreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	other, _, err := models.NewAPIKey("old", "admin", models.DefaultTenantID, nil, 0)
+	require.NoError(t, err)
+	other.CreatedAt = key.CreatedAt.Add(time.Second)
+	require.NoError(t, keys.Save(key))
+	require.NoError(t, keys.Save(other))
+	require.ErrorIs(t, keys.Save(key), storage.ErrAlreadyExist)
+	require.NoError(t, keys.Revoke(other.ID))
+	require.ErrorIs(t, keys.Revoke("unknown"), storage.ErrNotFound)
+	require.NoError(t, keys.Close())
+
+	// raw keys are never written
+	data, err := os.ReadFile(filepath.Join(dataDir, "api_keys.jsonl"))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), rawKey)
+
+	keys, err = storage.OpenAPIKeyStorage(dataDir)
+	require.NoError(t, err)
+	defer keys.Close()
+	restored, err := keys.GetByHash(key.HashedKey)
+	require.NoError(t, err)
+	require.Equal(t, key, restored)
+	revoked, err := keys.Get(other.ID)
+	require.NoError(t, err)
+	require.True(t, revoked.Revoked)
+	all, err := keys.List()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+}
+
+func TestImageStorageReopen(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	store := blob.NewLocalStore(t.TempDir())
+	images, err := storage.OpenImageStorage(store, dataDir)
+	require.NoError(t, err)
+	id1 := saveTestImage(t, images, "laptop1", "image1")
+	id2 := saveTestImage(t, images, "laptop1", "image2")
+	id3 := saveTestImage(t, images, "laptop2", "image1")
+	require.NoError(t, images.SaveThumbnail(ctx, "tenant1", id1, 128, ".png", *bytes.NewBufferString("thumbnail1")))
+	require.NoError(t, images.Reorder("tenant1", "laptop1", []string{id2, id1}))
+	require.NoError(t, images.SetPrimary("tenant1", "laptop1", id1))
+	saved, err := images.List("tenant1", "laptop1")
+	require.NoError(t, err)
+
+	images, err = storage.OpenImageStorage(store, dataDir)
+	require.NoError(t, err)
+	restored, err := images.List("tenant1", "laptop1")
+	require.NoError(t, err)
+	require.Len(t, restored, 2)
+	for i := range saved {
+		require.Equal(t, saved[i].ID, restored[i].ID)
+		require.Equal(t, saved[i].Thumbnails, restored[i].Thumbnails)
+		require.True(t, saved[i].UploadedAt.Equal(restored[i].UploadedAt))
+	}
+	primary, err := images.Primary("tenant1", "laptop1")
+	require.NoError(t, err)
+	require.Equal(t, id1, primary)
+	require.Equal(t, 2, images.RefCount(restored[1].Digest))
+
+	// content shared with the other laptop is kept after the image is deleted
+	require.NoError(t, images.DeleteByLaptop(ctx, "tenant1", "laptop1"))
+	images, err = storage.OpenImageStorage(store, dataDir)
+	require.NoError(t, err)
+	reader, err := images.Open(ctx, "tenant1", id3, 128)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	info, err := images.Get("tenant1", id1)
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
+
+func saveTestImage(t *testing.T, images *storage.ImageStorage, laptopID string, data string) string {
+	sum := sha256.Sum256([]byte(data))
+	id, err := images.Save(context.Background(), "tenant1", laptopID, ".png", "user1", strings.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	return id
+}
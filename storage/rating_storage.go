@@ -1,37 +1,312 @@
 package storage
 
-import "sync"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEventOutOfOrder = errors.New("rating event is older than the last event of the laptop")
+)
+
+// RatingSourceReview is the source of scores of approved reviews. Scores
+// given directly have no source.
+const RatingSourceReview = "review"
 
 type Rating struct {
 	Count uint32
 	Sum   float64
 }
 
+// Score is a single score given to the laptop.
+type Score struct {
+	RatedBy string
+	Value   float64
+}
+
+// RatingEvent is a change of the score of the user from the source. Deleted
+// event removes the score given before.
+type RatingEvent struct {
+	LaptopID string    `json:"laptop_id"`
+	RatedBy  string    `json:"rated_by"`
+	Source   string    `json:"source,omitempty"`
+	Score    float64   `json:"score"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// userScores are the scores of the user from every source.
+type userScores struct {
+	ratedBy string
+	values  map[string]float64
+}
+
+// value is the score of the approved review if the user has one,
+// otherwise the score given directly.
+func (u *userScores) value() float64 {
+	if value, ok := u.values[RatingSourceReview]; ok {
+		return value
+	}
+	return u.values[""]
+}
+
+// ratingRecord is the line of the rating journal.
+type ratingRecord struct {
+	TenantID string `json:"tenant_id"`
+	RatingEvent
+}
+
+type ratingKey struct {
+	tenantID string
+	laptopID string
+}
+
+// RatingStorage keeps at most one score of every user for the laptop from
+// every source. The score of the approved review of the user takes
+// precedence over the score given directly, so each user is counted once.
+// The rating is calculated from the scores, so it is always consistent.
+// Every change of the scores is kept as an event in time order.
 type RatingStorage struct {
-	mu     sync.RWMutex
-	rating map[string]*Rating
+	mu      sync.RWMutex
+	scores  map[ratingKey][]*userScores
+	events  map[ratingKey][]*RatingEvent
+	journal *journal
 }
 
 func NewRatingStorage() *RatingStorage {
 	return &RatingStorage{
-		rating: make(map[string]*Rating),
+		scores: make(map[ratingKey][]*userScores),
+		events: make(map[ratingKey][]*RatingEvent),
+	}
+}
+
+// OpenRatingStorage restores ratings from the journal of events in the data
+// folder, every new event is written to the journal before it is applied.
+func OpenRatingStorage(dataDir string) (*RatingStorage, error) {
+	rs := NewRatingStorage()
+	journal, err := openJournal(filepath.Join(dataDir, "ratings.jsonl"), func(data []byte) error {
+		record := &ratingRecord{}
+		err := json.Unmarshal(data, record)
+		if err != nil {
+			return err
+		}
+		_, err = rs.apply(record.TenantID, record.RatingEvent)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs.journal = journal
+	return rs, nil
+}
+
+// Check returns an error if new events cannot be written to the journal.
+func (rs *RatingStorage) Check(ctx context.Context) error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	return rs.journal.check()
+}
+
+func (rs *RatingStorage) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.journal.close()
+}
+
+// Rate sets the score of the user, a repeated score replaces the previous one.
+func (rs *RatingStorage) Rate(tenantID string, laptopId string, ratedBy string, score float64) (*Rating, error) {
+	return rs.Apply(tenantID, RatingEvent{LaptopID: laptopId, RatedBy: ratedBy, Score: score})
+}
+
+// Delete removes the score of the user and returns the rating without it.
+// The score of the approved review of the user is kept.
+func (rs *RatingStorage) Delete(tenantID string, laptopId string, ratedBy string) (*Rating, error) {
+	return rs.Apply(tenantID, RatingEvent{LaptopID: laptopId, RatedBy: ratedBy, Deleted: true})
+}
+
+// RateReview sets the score of the approved review of the author.
+func (rs *RatingStorage) RateReview(tenantID string, laptopId string, author string, score float64) (*Rating, error) {
+	return rs.Apply(tenantID, RatingEvent{LaptopID: laptopId, RatedBy: author, Source: RatingSourceReview, Score: score})
+}
+
+// DeleteReview removes the score of the review of the author, the score
+// the author gave directly counts again.
+func (rs *RatingStorage) DeleteReview(tenantID string, laptopId string, author string) (*Rating, error) {
+	return rs.Apply(tenantID, RatingEvent{LaptopID: laptopId, RatedBy: author, Source: RatingSourceReview, Deleted: true})
+}
+
+// Apply changes the score of the user by the event and returns the rating
+// of the laptop. Event without time happens now, but not before the last
+// event of the laptop. Events with time must be applied in time order.
+func (rs *RatingStorage) Apply(tenantID string, event RatingEvent) (*Rating, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.apply(tenantID, event)
+}
+
+// apply must be called with the lock held.
+func (rs *RatingStorage) apply(tenantID string, event RatingEvent) (*Rating, error) {
+	key := ratingKey{tenantID: tenantID, laptopID: event.LaptopID}
+	events := rs.events[key]
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+		// the clock may step backwards, events happening now keep the order
+		if len(events) > 0 && event.Time.Before(events[len(events)-1].Time) {
+			event.Time = events[len(events)-1].Time
+		}
+	}
+	if len(events) > 0 && event.Time.Before(events[len(events)-1].Time) {
+		return nil, ErrEventOutOfOrder
+	}
+
+	scores := rs.scores[key]
+	index := -1
+	for i, s := range scores {
+		if s.ratedBy == event.RatedBy {
+			index = i
+		}
+	}
+	if event.Deleted {
+		if index < 0 {
+			return nil, ErrNotFound
+		}
+		if _, ok := scores[index].values[event.Source]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	if rs.journal != nil {
+		err := rs.journal.append(&ratingRecord{TenantID: tenantID, RatingEvent: event})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case event.Deleted:
+		delete(scores[index].values, event.Source)
+		if len(scores[index].values) == 0 {
+			rs.scores[key] = append(scores[:index:index], scores[index+1:]...)
+		}
+	case index >= 0:
+		scores[index].values[event.Source] = event.Score
+	default:
+		rs.scores[key] = append(scores, &userScores{
+			ratedBy: event.RatedBy,
+			values:  map[string]float64{event.Source: event.Score},
+		})
 	}
+	rs.events[key] = append(events, &event)
+	return rs.aggregate(key), nil
 }
 
-func (rs *RatingStorage) Add(laptopId string, score float64) (*Rating, error) {
+// Events returns events of the laptop which happened before the time in time
+// order. Events of all laptops of the tenant are returned if laptopId is empty.
+func (rs *RatingStorage) Events(tenantID string, laptopId string, before time.Time) ([]*RatingEvent, error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	res := make([]*RatingEvent, 0)
+	for key, events := range rs.events {
+		if key.tenantID != tenantID || (len(laptopId) > 0 && key.laptopID != laptopId) {
+			continue
+		}
+		for _, event := range events {
+			if !event.Time.Before(before) {
+				break
+			}
+			other := *event
+			res = append(res, &other)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res, nil
+}
+
+// DeleteByLaptop removes the scores and events of the laptop, the journal
+// is rewritten without them.
+func (rs *RatingStorage) DeleteByLaptop(tenantID string, laptopId string) error {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
-	rating := rs.rating[laptopId]
-	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
+	key := ratingKey{tenantID: tenantID, laptopID: laptopId}
+	if _, ok := rs.events[key]; !ok {
+		return nil
+	}
+	if rs.journal != nil {
+		// events of every laptop keep their order, which is all the
+		// journal needs to be restored
+		records := make([]any, 0)
+		for other, events := range rs.events {
+			if other == key {
+				continue
+			}
+			for _, event := range events {
+				records = append(records, &ratingRecord{TenantID: other.tenantID, RatingEvent: *event})
+			}
+		}
+		err := rs.journal.rewrite(records)
+		if err != nil {
+			return err
 		}
-	} else {
+	}
+	delete(rs.scores, key)
+	delete(rs.events, key)
+	return nil
+}
+
+// Scores returns the score of every user of the laptop in the order
+// they were given.
+func (rs *RatingStorage) Scores(tenantID string, laptopId string) ([]*Score, error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	key := ratingKey{tenantID: tenantID, laptopID: laptopId}
+	scores := make([]*Score, 0, len(rs.scores[key]))
+	for _, score := range rs.scores[key] {
+		scores = append(scores, &Score{RatedBy: score.ratedBy, Value: score.value()})
+	}
+	return scores, nil
+}
+
+// Ratings returns ratings of all rated laptops of the tenant by laptop id.
+func (rs *RatingStorage) Ratings(tenantID string) (map[string]*Rating, error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	ratings := make(map[string]*Rating)
+	for key, scores := range rs.scores {
+		if key.tenantID != tenantID || len(scores) == 0 {
+			continue
+		}
+		rating := &Rating{}
+		for _, s := range scores {
+			rating.Count++
+			rating.Sum += s.value()
+		}
+		ratings[key.laptopID] = rating
+	}
+	return ratings, nil
+}
+
+// aggregate calculates the rating of the laptop from its scores.
+func (rs *RatingStorage) aggregate(key ratingKey) *Rating {
+	rating := &Rating{}
+	for _, s := range rs.scores[key] {
 		rating.Count++
-		rating.Sum += score
+		rating.Sum += s.value()
+	}
+	if rating.Count == 0 {
+		delete(rs.scores, key)
 	}
-	rs.rating[laptopId] = rating
-	return rating, nil
+	return rating
 }
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"main/models"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReviewStorage keeps at most one review of every user for the laptop.
+type ReviewStorage struct {
+	mu      sync.RWMutex
+	reviews map[string]*models.Review
+	journal *journal
+}
+
+func NewReviewStorage() *ReviewStorage {
+	return &ReviewStorage{
+		reviews: make(map[string]*models.Review),
+	}
+}
+
+// OpenReviewStorage restores reviews from the journal in the data folder.
+// Every saved or moderated review is written to the journal, the last
+// record wins, so reviews are restored together with the scores of
+// approved reviews in the rating storage.
+func OpenReviewStorage(dataDir string) (*ReviewStorage, error) {
+	s := NewReviewStorage()
+	journal, err := openJournal(filepath.Join(dataDir, "reviews.jsonl"), func(data []byte) error {
+		review := &models.Review{}
+		err := json.Unmarshal(data, review)
+		if err != nil {
+			return err
+		}
+		s.reviews[review.ID] = review
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Check returns an error if reviews cannot be written to the journal.
+func (s *ReviewStorage) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.journal.check()
+}
+
+func (s *ReviewStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.close()
+}
+
+// Save replaces the previous review of the author for the laptop, the
+// replaced review keeps its id and creation time and is moderated again.
+func (s *ReviewStorage) Save(review *models.Review) (*models.Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	other := review.Clone()
+	for _, existing := range s.reviews {
+		if existing.TenantID == review.TenantID &&
+			existing.LaptopID == review.LaptopID &&
+			existing.Author == review.Author {
+			other.ID = existing.ID
+			other.CreatedAt = existing.CreatedAt
+			other.Status = models.ReviewPending
+			other.ModeratedBy = ""
+			other.ModerationNote = ""
+			break
+		}
+	}
+	err := s.put(other)
+	if err != nil {
+		return nil, err
+	}
+	return other.Clone(), nil
+}
+
+func (s *ReviewStorage) Get(tenantID string, id string) (*models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	review, ok := s.reviews[id]
+	if !ok || review.TenantID != tenantID {
+		return nil, nil
+	}
+	return review.Clone(), nil
+}
+
+// List returns reviews with any of the statuses, the oldest first. Reviews
+// of all laptops are returned if laptopID is empty.
+func (s *ReviewStorage) List(tenantID string, laptopID string, statuses ...models.ReviewStatus) ([]*models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*models.Review, 0)
+	for _, review := range s.reviews {
+		if review.TenantID != tenantID || (len(laptopID) > 0 && review.LaptopID != laptopID) {
+			continue
+		}
+		for _, status := range statuses {
+			if review.Status == status {
+				res = append(res, review.Clone())
+				break
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func (s *ReviewStorage) SetStatus(
+	tenantID string,
+	id string,
+	status models.ReviewStatus,
+	moderatedBy string,
+	note string,
+) (*models.Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.reviews[id]
+	if !ok || review.TenantID != tenantID {
+		return nil, ErrNotFound
+	}
+	moderated := review.Clone()
+	moderated.Status = status
+	moderated.ModeratedBy = moderatedBy
+	moderated.ModerationNote = note
+	moderated.UpdatedAt = time.Now()
+	err := s.put(moderated)
+	if err != nil {
+		return nil, err
+	}
+	return moderated.Clone(), nil
+}
+
+// DeleteByLaptop removes the reviews of the laptop, the journal is
+// rewritten without them.
+func (s *ReviewStorage) DeleteByLaptop(tenantID string, laptopID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make([]string, 0)
+	records := make([]any, 0, len(s.reviews))
+	for id, review := range s.reviews {
+		if review.TenantID == tenantID && review.LaptopID == laptopID {
+			deleted = append(deleted, id)
+			continue
+		}
+		records = append(records, review)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	if s.journal != nil {
+		err := s.journal.rewrite(records)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range deleted {
+		delete(s.reviews, id)
+	}
+	return nil
+}
+
+// put must be called with the lock held.
+func (s *ReviewStorage) put(review *models.Review) error {
+	if s.journal != nil {
+		err := s.journal.append(review)
+		if err != nil {
+			return err
+		}
+	}
+	s.reviews[review.ID] = review
+	return nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"main/models"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type TenantStorage struct {
+	mu      sync.RWMutex
+	tenants map[string]*models.Tenant
+	journal *journal
+}
+
+func NewTenantStorage() *TenantStorage {
+	return &TenantStorage{
+		tenants: make(map[string]*models.Tenant),
+	}
+}
+
+// OpenTenantStorage restores tenants from the journal in the data folder.
+// Every saved tenant is written to the journal.
+func OpenTenantStorage(dataDir string) (*TenantStorage, error) {
+	s := NewTenantStorage()
+	journal, err := openJournal(filepath.Join(dataDir, "tenants.jsonl"), func(data []byte) error {
+		tenant := &models.Tenant{}
+		err := json.Unmarshal(data, tenant)
+		if err != nil {
+			return err
+		}
+		s.tenants[tenant.ID] = tenant
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Check returns an error if tenants cannot be written to the journal.
+func (s *TenantStorage) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.journal.check()
+}
+
+func (s *TenantStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.close()
+}
+
+func (s *TenantStorage) Save(tenant *models.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[tenant.ID]; ok {
+		return ErrAlreadyExist
+	}
+
+	if s.journal != nil {
+		err := s.journal.append(tenant)
+		if err != nil {
+			return err
+		}
+	}
+	s.tenants[tenant.ID] = tenant.Clone()
+	return nil
+}
+
+func (s *TenantStorage) Get(id string) (*models.Tenant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant, ok := s.tenants[id]
+	if !ok {
+		return nil, nil
+	}
+	return tenant.Clone(), nil
+}
+
+func (s *TenantStorage) List() ([]*models.Tenant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenants := make([]*models.Tenant, 0, len(s.tenants))
+	for _, tenant := range s.tenants {
+		tenants = append(tenants, tenant.Clone())
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+	return tenants, nil
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidOffset  = errors.New("chunk offset is beyond committed data")
+	ErrTooManyUploads = errors.New("user has maximum number of uploads in progress")
+)
+
+// UploadSession is an image upload in progress. Received data is kept
+// in the temporary file, so the upload can be resumed from Offset.
+type UploadSession struct {
+	ID        string
+	TenantID  string
+	LaptopID  string
+	ImageType string
+	Checksum  string
+	CreatedBy string
+	// ByteSize is the declared size of the image, 0 if it is unknown.
+	ByteSize  int64
+	Offset    int64
+	Path      string
+	UpdatedAt time.Time
+}
+
+func (s *UploadSession) Clone() *UploadSession {
+	other := *s
+	return &other
+}
+
+type UploadStorage struct {
+	mu          sync.Mutex
+	folder      string
+	ttl         time.Duration
+	maxSessions int
+	sessions    map[string]*UploadSession
+}
+
+// NewUploadStorage keeps temporary files in the folder. Sessions
+// not updated for ttl are removed. Every user has at most maxSessions
+// sessions in a tenant, 0 disables the limit.
+func NewUploadStorage(folder string, ttl time.Duration, maxSessions int) *UploadStorage {
+	return &UploadStorage{
+		folder:      folder,
+		ttl:         ttl,
+		maxSessions: maxSessions,
+		sessions:    make(map[string]*UploadSession),
+	}
+}
+
+// Create returns ErrTooManyUploads if the creator of the session
+// already has the maximum number of sessions.
+func (s *UploadStorage) Create(session *UploadSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired(time.Now())
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrAlreadyExist
+	}
+	if s.maxSessions > 0 {
+		count := 0
+		for _, other := range s.sessions {
+			if other.TenantID == session.TenantID && other.CreatedBy == session.CreatedBy {
+				count++
+			}
+		}
+		if count >= s.maxSessions {
+			return ErrTooManyUploads
+		}
+	}
+
+	other := session.Clone()
+	other.Path = filepath.Join(s.folder, fmt.Sprintf("upload-%s", session.ID))
+	other.Offset = 0
+	other.UpdatedAt = time.Now()
+	err := os.MkdirAll(s.folder, 0700)
+	if err != nil {
+		return fmt.Errorf("cannot create upload folder: (%w)", err)
+	}
+	file, err := os.Create(other.Path)
+	if err != nil {
+		return fmt.Errorf("cannot create upload file: (%w)", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close upload file: (%w)", err)
+	}
+	s.sessions[other.ID] = other
+	return nil
+}
+
+func (s *UploadStorage) Get(id string) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return session.Clone(), nil
+}
+
+// PendingBytes returns the size of images being uploaded by the user in
+// the tenant, except the session with exceptID. The declared size is
+// counted, or the received data if the size is unknown.
+func (s *UploadStorage) PendingBytes(tenantID string, createdBy string, exceptID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var size int64
+	for _, session := range s.sessions {
+		if session.TenantID == tenantID && session.CreatedBy == createdBy && session.ID != exceptID {
+			size += max(session.ByteSize, session.Offset)
+		}
+	}
+	return size, nil
+}
+
+// Append writes the chunk starting at offset and returns the committed offset.
+// Data before the committed offset is already stored and is skipped, so
+// chunks resent after a broken stream are accepted.
+func (s *UploadStorage) Append(id string, offset int64, data []byte) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if offset > session.Offset {
+		return session.Offset, ErrInvalidOffset
+	}
+	skip := session.Offset - offset
+	if skip >= int64(len(data)) {
+		return session.Offset, nil
+	}
+	data = data[skip:]
+
+	file, err := os.OpenFile(session.Path, os.O_WRONLY, 0600)
+	if err != nil {
+		return session.Offset, fmt.Errorf("cannot open upload file: (%w)", err)
+	}
+	defer file.Close()
+
+	// drop data of the write failed in the middle
+	err = file.Truncate(session.Offset)
+	if err != nil {
+		return session.Offset, fmt.Errorf("cannot truncate upload file: (%w)", err)
+	}
+	_, err = file.WriteAt(data, session.Offset)
+	if err != nil {
+		return session.Offset, fmt.Errorf("cannot write upload file: (%w)", err)
+	}
+	session.Offset += int64(len(data))
+	session.UpdatedAt = time.Now()
+	return session.Offset, nil
+}
+
+// Remove deletes the session and its temporary file.
+func (s *UploadStorage) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return removeUploadFile(session.Path)
+}
+
+func (s *UploadStorage) removeExpired(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	for id, session := range s.sessions {
+		if now.Sub(session.UpdatedAt) > s.ttl {
+			delete(s.sessions, id)
+			removeUploadFile(session.Path)
+		}
+	}
+}
+
+func removeUploadFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove upload file: (%w)", err)
+	}
+	return nil
+}
@@ -1,13 +1,17 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
 	"main/models"
+	"path/filepath"
 	"sync"
 )
 
 type UserStorage struct {
-	mu    sync.RWMutex
-	users map[string]*models.User
+	mu      sync.RWMutex
+	users   map[string]*models.User
+	journal *journal
 }
 
 func NewUserStorage() *UserStorage {
@@ -16,6 +20,41 @@ func NewUserStorage() *UserStorage {
 	}
 }
 
+// OpenUserStorage restores users from the journal in the data folder. Every
+// saved or updated user is written to the journal, the last record wins.
+func OpenUserStorage(dataDir string) (*UserStorage, error) {
+	s := NewUserStorage()
+	journal, err := openJournal(filepath.Join(dataDir, "users.jsonl"), func(data []byte) error {
+		user := &models.User{}
+		err := json.Unmarshal(data, user)
+		if err != nil {
+			return err
+		}
+		s.users[user.UserName] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Check returns an error if users cannot be written to the journal.
+func (s *UserStorage) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.journal.check()
+}
+
+func (s *UserStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.close()
+}
+
 func (s *UserStorage) Save(user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -24,8 +63,18 @@ func (s *UserStorage) Save(user *models.User) error {
 		return ErrAlreadyExist
 	}
 
-	s.users[user.UserName] = user.Clone()
-	return nil
+	return s.put(user)
+}
+
+func (s *UserStorage) Update(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.UserName]; !ok {
+		return ErrNotFound
+	}
+
+	return s.put(user)
 }
 
 func (s *UserStorage) Get(username string) (*models.User, error) {
@@ -38,3 +87,15 @@ func (s *UserStorage) Get(username string) (*models.User, error) {
 	}
 	return user.Clone(), nil
 }
+
+// put must be called with the lock held.
+func (s *UserStorage) put(user *models.User) error {
+	if s.journal != nil {
+		err := s.journal.append(user)
+		if err != nil {
+			return err
+		}
+	}
+	s.users[user.UserName] = user.Clone()
+	return nil
+}
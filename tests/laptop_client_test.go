@@ -2,22 +2,40 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
 	"io"
+	"main/blob"
+	"main/client"
+	"main/models"
 	"main/pb"
 	"main/sample"
 	"main/serializer"
 	"main/service"
 	"main/storage"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestClientCreateLaptop(t *testing.T) {
@@ -37,7 +55,7 @@ func TestClientCreateLaptop(t *testing.T) {
 	require.NotNil(t, res)
 	require.Equal(t, expectedID, res.Id)
 
-	other, err := laptopStorage.Get(laptop.Id)
+	other, err := laptopStorage.Get(models.DefaultTenantID, laptop.Id)
 	require.NoError(t, err)
 	require.NotNil(t, other)
 	requireSameLaptops(t, other, laptop)
@@ -80,7 +98,7 @@ func TestClientSearchLaptop(t *testing.T) {
 			laptop.RAM = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
 			excpectedIDs[laptop.Id] = true
 		}
-		err := storage.Save(laptop)
+		err := storage.Save(models.DefaultTenantID, laptop)
 		require.NoError(t, err)
 	}
 	addr := startTestLaptopServer(t, storage, nil, nil)
@@ -107,10 +125,10 @@ func TestClientUploadImage(t *testing.T) {
 	ctx := context.Background()
 	testImageFolder := "../tmp"
 	laptopStorage := storage.NewInMemoryLaptopStorage()
-	imageStorage := storage.NewImageStorage(testImageFolder)
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(testImageFolder))
 
 	laptop := sample.NewLaptop()
-	err := laptopStorage.Save(laptop)
+	err := laptopStorage.Save(models.DefaultTenantID, laptop)
 	require.NoError(t, err)
 
 	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
@@ -159,52 +177,665 @@ func TestClientUploadImage(t *testing.T) {
 	require.NoError(t, err)
 	require.NotZero(t, resp.GetId())
 	require.EqualValues(t, size, resp.GetByteSize())
-	savedImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, resp.GetId(), imageType)
+	require.Len(t, resp.GetDigest(), 64)
+	savedImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, resp.GetDigest(), imageType)
 	require.FileExists(t, savedImagePath)
 	require.NoError(t, os.Remove(savedImagePath))
 }
 
-func TestClientRateLaptop(t *testing.T) {
+func TestClientDownloadImage(t *testing.T) {
+	t.Parallel()
 	ctx := context.Background()
+	testImageFolder := t.TempDir()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(testImageFolder))
+
+	laptop := sample.NewLaptop()
+	err := laptopStorage.Save(models.DefaultTenantID, laptop)
+	require.NoError(t, err)
+	data, err := os.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+	sum := sha256.Sum256(data)
+	imageID, err := imageStorage.Save(ctx, models.DefaultTenantID, laptop.GetId(), ".jpg", "vendor1", bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	listResp, err := pb.NewLaptopServiceClient(conn).ListLaptopImages(ctx, &pb.ListLaptopImagesRequest{
+		LaptopId: laptop.GetId(),
+	})
+	require.NoError(t, err)
+	require.Len(t, listResp.GetImages(), 1)
+	image := listResp.GetImages()[0]
+	require.Equal(t, imageID, image.GetId())
+	require.Equal(t, ".jpg", image.GetImageType())
+	require.EqualValues(t, len(data), image.GetByteSize())
+	require.Equal(t, "vendor1", image.GetUploadedBy())
+
+	laptopClient := client.NewLaptopClient(conn)
+	imagePath, err := laptopClient.DownloadImage(ctx, imageID, 0, t.TempDir())
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	require.Equal(t, data, downloaded)
+
+	_, err = laptopClient.DownloadImage(ctx, "unknown", 0, t.TempDir())
+	require.Equal(t, codes.NotFound, status.Code(errors.Unwrap(err)))
+}
+
+func TestClientDownloadThumbnail(t *testing.T) {
 	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(t.TempDir()))
+	laptop := sample.NewLaptop()
+	err := laptopStorage.Save(models.DefaultTenantID, laptop)
+	require.NoError(t, err)
+
+	thumbnailer, err := service.NewThumbnailer([]uint32{64, 32})
+	require.NoError(t, err)
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{ImageStorage: imageStorage, Thumbnailer: thumbnailer})
+	serverAddr := serveTestLaptopServer(t, server)
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	imageID := uploadTestImage(t, pb.NewLaptopServiceClient(conn), laptop.GetId(), "../tmp/laptop.jpg")
+	info, err := imageStorage.Get(models.DefaultTenantID, imageID)
+	require.NoError(t, err)
+	require.Len(t, info.Thumbnails, 2)
 
+	laptopClient := client.NewLaptopClient(conn)
+	for _, size := range []uint32{32, 64} {
+		imagePath, err := laptopClient.DownloadImage(ctx, imageID, size, t.TempDir())
+		require.NoError(t, err)
+		file, err := os.Open(imagePath)
+		require.NoError(t, err)
+		config, format, err := image.DecodeConfig(file)
+		file.Close()
+		require.NoError(t, err)
+		require.Equal(t, "jpeg", format)
+		require.EqualValues(t, size, max(config.Width, config.Height))
+	}
+
+	_, err = laptopClient.DownloadImage(ctx, imageID, 100, t.TempDir())
+	require.Equal(t, codes.NotFound, status.Code(errors.Unwrap(err)))
+}
+
+func TestClientUploadImageResume(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
 	laptopStorage := storage.NewInMemoryLaptopStorage()
-	ratingStorage := storage.NewRatingStorage()
+	blobStore := blob.NewMemoryStore()
+	imageStorage := storage.NewImageStorage(blobStore)
 	laptop := sample.NewLaptop()
-	err := laptopStorage.Save(laptop)
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	imagePath := filepath.Join(t.TempDir(), "noise.png")
+	writeNoisePNG(t, imagePath, 300, 300)
+	data, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+
+	// the first upload stream breaks after three chunks
+	var calls, received int
+	var mu sync.Mutex
+	breaker := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		return handler(srv, &breakingStream{ServerStream: ss, breakAfter: 3, broken: call == 1, received: func(n int) {
+			mu.Lock()
+			received += n
+			mu.Unlock()
+		}})
+	}
+	uploadStorage := storage.NewUploadStorage(t.TempDir(), time.Hour, 0)
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{ImageStorage: imageStorage, UploadStorage: uploadStorage})
+	serverAddr := serveTestLaptopServer(t, server, grpc.StreamInterceptor(breaker))
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	imageID, err := client.NewLaptopClient(conn).UploadImage(ctx, laptop.GetId(), imagePath)
+	require.NoError(t, err)
+	mu.Lock()
+	require.Equal(t, 2, calls)
+	require.Less(t, received, 2*len(data))
+	mu.Unlock()
+
+	info, err := imageStorage.Get(models.DefaultTenantID, imageID)
 	require.NoError(t, err)
-	serverAddr := startTestLaptopServer(t, laptopStorage, nil, ratingStorage)
+	require.Equal(t, ".png", info.Type)
+	reader, err := blobStore.Get(ctx, info.Key)
+	require.NoError(t, err)
+	defer reader.Close()
+	saved, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, data, saved)
+}
+
+type breakingStream struct {
+	grpc.ServerStream
+	breakAfter int
+	broken     bool
+	count      int
+	received   func(n int)
+}
+
+func (s *breakingStream) RecvMsg(m any) error {
+	if s.broken && s.count == s.breakAfter {
+		return status.Error(codes.Unavailable, "connection is lost")
+	}
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	s.count++
+	s.received(len(m.(*pb.UploadImageRequest).GetChunk().GetData()))
+	return nil
+}
+
+func writeNoisePNG(t *testing.T, path string, width int, height int) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	_, err := rand.Read(img.Pix)
+	require.NoError(t, err)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, png.Encode(file, img))
+}
+
+func TestClientUploadImageDeduplication(t *testing.T) {
+	t.Parallel()
+	testImageFolder := t.TempDir()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(testImageFolder))
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop1))
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop2))
+
+	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
 	client := newTestLaptopClient(t, serverAddr)
 
-	stream, err := client.RateLaptop(ctx)
+	imageID1 := uploadTestImage(t, client, laptop1.GetId(), "../tmp/laptop.jpg")
+	imageID2 := uploadTestImage(t, client, laptop1.GetId(), "../tmp/laptop.jpg")
+	imageID3 := uploadTestImage(t, client, laptop2.GetId(), "../tmp/laptop.jpg")
+	require.Equal(t, imageID1, imageID2)
+	require.NotEqual(t, imageID1, imageID3)
+
+	info1, err := imageStorage.Get(models.DefaultTenantID, imageID1)
+	require.NoError(t, err)
+	info3, err := imageStorage.Get(models.DefaultTenantID, imageID3)
+	require.NoError(t, err)
+	require.Equal(t, info1.Key, info3.Key)
+	require.Equal(t, 2, imageStorage.RefCount(info1.Digest))
+
+	files, err := os.ReadDir(testImageFolder)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestClientUploadImageChecksumMismatch(t *testing.T) {
+	t.Parallel()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(t.TempDir()))
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
+	client := newTestLaptopClient(t, serverAddr)
+	data, err := os.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+
+	stream, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId: laptop.GetId(),
+				Checksum: strings.Repeat("0", 64),
+			},
+		},
+	})
+	require.NoError(t, err)
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: data},
+	})
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.DataLoss, status.Code(err))
+
+	images, err := imageStorage.List(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Empty(t, images)
+}
+
+func TestClientManageImages(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	testImageFolder := t.TempDir()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(testImageFolder))
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
+	client := newTestLaptopClient(t, serverAddr)
+
+	noisePath := filepath.Join(t.TempDir(), "noise.png")
+	writeNoisePNG(t, noisePath, 16, 16)
+	imageID1 := uploadTestImage(t, client, laptop.GetId(), "../tmp/laptop.jpg")
+	imageID2 := uploadTestImage(t, client, laptop.GetId(), noisePath)
+	imageID3 := uploadTestImage(t, client, laptop.GetId(), "../tmp/gopher.webp")
+
+	found := searchTestLaptop(t, client, laptop.GetId())
+	require.Equal(t, []string{imageID1, imageID2, imageID3}, imageIDs(found.GetImages()))
+	require.Equal(t, imageID1, found.GetPrimaryImageId())
+
+	primaryResp, err := client.SetPrimaryImage(ctx, &pb.SetPrimaryImageRequest{
+		LaptopId: laptop.GetId(),
+		ImageId:  imageID2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, imageID2, primaryResp.GetLaptop().GetPrimaryImageId())
+	_, err = client.SetPrimaryImage(ctx, &pb.SetPrimaryImageRequest{
+		LaptopId: laptop.GetId(),
+		ImageId:  "unknown",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	reorderResp, err := client.ReorderImages(ctx, &pb.ReorderImagesRequest{
+		LaptopId: laptop.GetId(),
+		ImageIds: []string{imageID3, imageID1, imageID2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{imageID3, imageID1, imageID2}, imageIDs(reorderResp.GetLaptop().GetImages()))
+	for _, ids := range [][]string{
+		{imageID3, imageID1},
+		{imageID3, imageID1, imageID1},
+		{imageID3, imageID1, "unknown"},
+	} {
+		_, err = client.ReorderImages(ctx, &pb.ReorderImagesRequest{LaptopId: laptop.GetId(), ImageIds: ids})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err = client.DeleteImage(ctx, &pb.DeleteImageRequest{ImageId: imageID2})
+	require.NoError(t, err)
+	_, err = client.DeleteImage(ctx, &pb.DeleteImageRequest{ImageId: imageID2})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// deleted primary image falls back to the first one
+	found = searchTestLaptop(t, client, laptop.GetId())
+	require.Equal(t, []string{imageID3, imageID1}, imageIDs(found.GetImages()))
+	require.Equal(t, imageID3, found.GetPrimaryImageId())
+	files, err := os.ReadDir(testImageFolder)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestClientDeleteLaptop(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	testImageFolder := t.TempDir()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewLocalStore(testImageFolder))
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop1))
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop2))
+
+	serverAddr := startTestLaptopServer(t, laptopStorage, imageStorage, nil)
+	client := newTestLaptopClient(t, serverAddr)
+	uploadTestImage(t, client, laptop1.GetId(), "../tmp/laptop.jpg")
+	uploadTestImage(t, client, laptop1.GetId(), "../tmp/gopher.webp")
+	imageID := uploadTestImage(t, client, laptop2.GetId(), "../tmp/laptop.jpg")
+
+	_, err := client.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{LaptopId: laptop1.GetId()})
+	require.NoError(t, err)
+	_, err = client.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{LaptopId: laptop1.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	found, err := laptopStorage.Get(models.DefaultTenantID, laptop1.GetId())
+	require.NoError(t, err)
+	require.Nil(t, found)
+	images, err := imageStorage.List(models.DefaultTenantID, laptop1.GetId())
+	require.NoError(t, err)
+	require.Empty(t, images)
+
+	// content shared with another laptop is kept
+	info, err := imageStorage.Get(models.DefaultTenantID, imageID)
+	require.NoError(t, err)
+	require.Equal(t, 1, imageStorage.RefCount(info.Digest))
+	files, err := os.ReadDir(testImageFolder)
 	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, info.Key, files[0].Name())
+}
+
+func searchTestLaptop(t *testing.T, client pb.LaptopServiceClient, laptopID string) *pb.Laptop {
+	stream, err := client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64},
+	})
+	require.NoError(t, err)
+	for {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		if resp.GetLaptop().GetId() == laptopID {
+			return resp.GetLaptop()
+		}
+	}
+}
+
+func imageIDs(images []*pb.Image) []string {
+	res := make([]string, 0, len(images))
+	for _, image := range images {
+		res = append(res, image.GetId())
+	}
+	return res
+}
+
+func TestClientRateLaptop(t *testing.T) {
+	ctx := context.Background()
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	laptop := sample.NewLaptop()
+	err := laptopStorage.Save(models.DefaultTenantID, laptop)
+	require.NoError(t, err)
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{RatingStorage: ratingStorage})
+
 	scores := []float64{8, 7.5, 10}
 	avg := []float64{8, 7.75, 8.5}
-	n := len(scores)
-	for i := 0; i < n; i++ {
-		req := &pb.RateLaptopRequest{
-			LaptopId: laptop.GetId(),
-			Score:    scores[i],
+	clients := make([]pb.LaptopServiceClient, len(scores))
+	for i := range scores {
+		principal := &service.Principal{Username: fmt.Sprintf("user%d", i+1), Role: "user"}
+		clients[i] = newTestLaptopClient(t, serveTestLaptopServer(t, server, withTestPrincipal(principal)...))
+	}
+	for i, score := range scores {
+		resp := rateTestLaptop(t, clients[i], laptop.GetId(), score)
+		require.Equal(t, uint32(i+1), resp.GetRatedCount())
+		require.Equal(t, avg[i], resp.GetAverageScore())
+		require.Equal(t, avg[i], resp.GetAvarageScore())
+	}
+
+	// repeated score replaces the previous one
+	resp := rateTestLaptop(t, clients[0], laptop.GetId(), 5)
+	require.Equal(t, uint32(3), resp.GetRatedCount())
+	require.Equal(t, 7.5, resp.GetAverageScore())
+
+	deleted, err := clients[1].DeleteMyRating(ctx, &pb.DeleteMyRatingRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), deleted.GetRatedCount())
+	require.Equal(t, 7.5, deleted.GetAverageScore())
+	_, err = clients[1].DeleteMyRating(ctx, &pb.DeleteMyRatingRequest{LaptopId: laptop.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	scoresOfLaptop, err := ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{
+		{RatedBy: "user1", Value: 5},
+		{RatedBy: "user3", Value: 10},
+	}, scoresOfLaptop)
+
+	anonymous := newTestLaptopClient(t, serveTestLaptopServer(t, server))
+	stream, err := anonymous.RateLaptop(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 1}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClientLaptopRatingStats(t *testing.T) {
+	ctx := context.Background()
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	single, popular, unrated := sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{single, popular, unrated} {
+		require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+	}
+	_, err := ratingStorage.Rate(models.DefaultTenantID, single.GetId(), "user0", 10)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		score := 9.0
+		if i%2 == 1 {
+			score = 8.5
 		}
+		_, err := ratingStorage.Rate(models.DefaultTenantID, popular.GetId(), fmt.Sprintf("user%d", i), score)
+		require.NoError(t, err)
+	}
+	addr := startTestLaptopServer(t, laptopStorage, nil, ratingStorage)
+	client := newTestLaptopClient(t, addr)
+
+	rating, err := client.GetLaptopRating(ctx, &pb.GetLaptopRatingRequest{LaptopId: popular.GetId()})
+	require.NoError(t, err)
+	require.EqualValues(t, 200, rating.GetSummary().GetRatedCount())
+	require.Equal(t, 8.75, rating.GetSummary().GetAverageScore())
+	require.Equal(t, 8.75, rating.GetMedian())
+	require.InDelta(t, 0.25, rating.GetStandardDeviation(), 1e-9)
+	require.Len(t, rating.GetHistogram(), 19)
+	require.Equal(t, &pb.RatingBucket{Score: 8.5, Count: 100}, rating.GetHistogram()[15])
+	_, err = client.GetLaptopRating(ctx, &pb.GetLaptopRatingRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	filter := &pb.Filter{MaxPriceUsd: math.MaxFloat64, SortBy: pb.SortBy_SORT_BY_RATING}
+	require.Equal(t, []string{popular.GetId(), single.GetId(), unrated.GetId()}, searchTestLaptopIDs(t, client, filter))
+	filter.MinRating = 6
+	require.Equal(t, []string{popular.GetId()}, searchTestLaptopIDs(t, client, filter))
+}
 
-		err := stream.Send(req)
+func TestClientRatingHistory(t *testing.T) {
+	ctx := context.Background()
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+	day := func(d int, hour int) time.Time {
+		return time.Date(2026, time.January, d, hour, 0, 0, 0, time.UTC)
+	}
+	for _, event := range []storage.RatingEvent{
+		{RatedBy: "user1", Score: 6, Time: day(1, 10)},
+		{RatedBy: "user2", Score: 8, Time: day(6, 10)},
+		{RatedBy: "user1", Score: 10, Time: day(13, 10)},
+		{RatedBy: "user2", Deleted: true, Time: day(14, 10)},
+	} {
+		event.LaptopID = laptop.GetId()
+		_, err := ratingStorage.Apply(models.DefaultTenantID, event)
 		require.NoError(t, err)
 	}
+	_, err := ratingStorage.Apply(models.DefaultTenantID, storage.RatingEvent{
+		LaptopID: laptop.GetId(), RatedBy: "user3", Score: 5, Time: day(2, 10),
+	})
+	require.ErrorIs(t, err, storage.ErrEventOutOfOrder)
+	addr := startTestLaptopServer(t, laptopStorage, nil, ratingStorage)
+	client := newTestLaptopClient(t, addr)
 
-	err = stream.CloseSend()
+	// range is aligned to Monday 5 and Monday 26 of January
+	history, err := client.GetRatingHistory(ctx, &pb.GetRatingHistoryRequest{
+		LaptopId:   laptop.GetId(),
+		BucketSize: pb.GetRatingHistoryRequest_WEEK,
+		From:       timestamppb.New(day(7, 0)),
+		To:         timestamppb.New(day(25, 12)),
+	})
 	require.NoError(t, err)
+	buckets := history.GetBuckets()
+	require.Len(t, buckets, 3)
+	require.Equal(t, day(5, 0), buckets[0].GetStart().AsTime())
+	require.Equal(t, day(26, 0), buckets[2].GetEnd().AsTime())
+	require.EqualValues(t, 1, buckets[0].GetNewScores())
+	require.Equal(t, 8.0, buckets[0].GetNewAverageScore())
+	require.EqualValues(t, 2, buckets[0].GetRating().GetRatedCount())
+	require.Equal(t, 7.0, buckets[0].GetRating().GetAverageScore())
+	require.EqualValues(t, 1, buckets[1].GetNewScores())
+	require.Equal(t, 10.0, buckets[1].GetNewAverageScore())
+	require.EqualValues(t, 1, buckets[1].GetRating().GetRatedCount())
+	require.Equal(t, 10.0, buckets[1].GetRating().GetAverageScore())
+	require.Zero(t, buckets[2].GetNewScores())
+	require.Equal(t, 10.0, buckets[2].GetRating().GetAverageScore())
 
-	for i := 0; ; i++ {
-		resp, err := stream.Recv()
+	history, err = client.GetRatingHistory(ctx, &pb.GetRatingHistoryRequest{
+		LaptopId:   laptop.GetId(),
+		BucketSize: pb.GetRatingHistoryRequest_DAY,
+		To:         timestamppb.New(day(14, 0)),
+	})
+	require.NoError(t, err)
+	require.Len(t, history.GetBuckets(), 12)
+	require.Equal(t, day(2, 0), history.GetBuckets()[0].GetStart().AsTime())
+
+	_, err = client.GetRatingHistory(ctx, &pb.GetRatingHistoryRequest{
+		LaptopId: laptop.GetId(),
+		From:     timestamppb.New(day(20, 0)),
+		To:       timestamppb.New(day(10, 0)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetRatingHistory(ctx, &pb.GetRatingHistoryRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientTrendingLaptops(t *testing.T) {
+	ctx := context.Background()
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	rising, falling, single, old := sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{rising, falling, single, old} {
+		require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+	}
+	longAgo := time.Now().Add(-8 * 24 * time.Hour)
+	recently := time.Now().Add(-24 * time.Hour)
+	rate := func(laptopID string, at time.Time, count int, score float64) {
+		for i := 0; i < count; i++ {
+			_, err := ratingStorage.Apply(models.DefaultTenantID, storage.RatingEvent{
+				LaptopID: laptopID,
+				RatedBy:  fmt.Sprintf("user%v-%d", at.Unix(), i),
+				Score:    score,
+				Time:     at.Add(time.Duration(i) * time.Second),
+			})
+			require.NoError(t, err)
+		}
+	}
+	rate(rising.GetId(), recently, 5, 9)
+	rate(falling.GetId(), longAgo, 10, 9)
+	rate(falling.GetId(), recently, 5, 3)
+	rate(single.GetId(), recently, 1, 10)
+	rate(old.GetId(), longAgo, 20, 10)
+	// ratings of deleted laptops are not trending
+	rate("deleted", recently, 20, 10)
+
+	addr := startTestLaptopServer(t, laptopStorage, nil, ratingStorage)
+	client := newTestLaptopClient(t, addr)
+	resp, err := client.TrendingLaptops(ctx, &pb.TrendingLaptopsRequest{})
+	require.NoError(t, err)
+	ids := make([]string, 0)
+	for _, trending := range resp.GetLaptops() {
+		ids = append(ids, trending.GetLaptop().GetId())
+	}
+	require.Equal(t, []string{rising.GetId(), falling.GetId(), single.GetId()}, ids)
+	require.EqualValues(t, 5, resp.GetLaptops()[0].GetRecentScores())
+	require.Greater(t, resp.GetLaptops()[0].GetScoreDelta(), 0.0)
+	require.Less(t, resp.GetLaptops()[1].GetScoreDelta(), 0.0)
+	require.EqualValues(t, 15, resp.GetLaptops()[1].GetRating().GetRatedCount())
+
+	resp, err = client.TrendingLaptops(ctx, &pb.TrendingLaptopsRequest{WindowDays: 10, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, resp.GetLaptops(), 1)
+	require.Equal(t, old.GetId(), resp.GetLaptops()[0].GetLaptop().GetId())
+}
+
+func searchTestLaptopIDs(t *testing.T, client pb.LaptopServiceClient, filter *pb.Filter) []string {
+	stream, err := client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: filter})
+	require.NoError(t, err)
+	var ids []string
+	for {
+		response, err := stream.Recv()
 		if err == io.EOF {
-			require.Equal(t, n, i)
-			return
+			return ids
 		}
 		require.NoError(t, err)
-		require.Equal(t, laptop.GetId(), resp.LaptopId)
-		require.Equal(t, uint32(i+1), resp.GetRatedCount())
-		require.Equal(t, avg[i], resp.GetAvarageScore())
+		ids = append(ids, response.GetLaptop().GetId())
+	}
+}
+
+func TestClientRateLaptopInvalidScore(t *testing.T) {
+	ctx := context.Background()
+	t.Parallel()
+
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+	ratingScale, err := service.NewRatingScale(1, 5, 0.5)
+	require.NoError(t, err)
+	ratingStorage := storage.NewRatingStorage()
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{RatingStorage: ratingStorage, RatingScale: ratingScale})
+	principal := &service.Principal{Username: "user1", Role: "user"}
+	client := newTestLaptopClient(t, serveTestLaptopServer(t, server, withTestPrincipal(principal)...))
+
+	scale, err := client.GetRatingScale(ctx, &pb.GetRatingScaleRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 1.0, scale.GetMin())
+	require.Equal(t, 5.0, scale.GetMax())
+	require.Equal(t, 0.5, scale.GetStep())
+
+	requests := []struct {
+		laptopID     string
+		score        float64
+		expectedCode codes.Code
+	}{
+		{laptopID: laptop.GetId(), score: 4, expectedCode: codes.OK},
+		{laptopID: laptop.GetId(), score: math.NaN(), expectedCode: codes.InvalidArgument},
+		{laptopID: laptop.GetId(), score: -1, expectedCode: codes.InvalidArgument},
+		{laptopID: laptop.GetId(), score: 1e308, expectedCode: codes.InvalidArgument},
+		{laptopID: laptop.GetId(), score: 4.25, expectedCode: codes.InvalidArgument},
+		{laptopID: "unknown", score: 3, expectedCode: codes.NotFound},
+		{laptopID: laptop.GetId(), score: 4.5, expectedCode: codes.OK},
+	}
+	stream, err := client.RateLaptop(ctx)
+	require.NoError(t, err)
+	for _, req := range requests {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: req.laptopID, Score: req.score})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	for _, req := range requests {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, req.laptopID, resp.GetLaptopId())
+		require.EqualValues(t, req.expectedCode, resp.GetError().GetCode(), req.score)
 	}
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	ratings, err := ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 4.5}}, ratings)
+}
+
+func rateTestLaptop(t *testing.T, client pb.LaptopServiceClient, laptopID string, score float64) *pb.RateLaptopResponse {
+	stream, err := client.RateLaptop(context.Background())
+	require.NoError(t, err)
+	err = stream.Send(&pb.RateLaptopRequest{LaptopId: laptopID, Score: score})
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, laptopID, resp.GetLaptopId())
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+	return resp
 }
 
 func startTestLaptopServer(t *testing.T,
@@ -212,8 +843,12 @@ func startTestLaptopServer(t *testing.T,
 	imageStorage service.ImageStorager,
 	ratingStorage service.RatingStorager,
 ) string {
-	server := service.NewLaptopServer(laptopStorage, imageStorage, ratingStorage)
-	grpsServer := grpc.NewServer()
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{ImageStorage: imageStorage, RatingStorage: ratingStorage})
+	return serveTestLaptopServer(t, server)
+}
+
+func serveTestLaptopServer(t *testing.T, server *service.LaptopServer, opts ...grpc.ServerOption) string {
+	grpsServer := grpc.NewServer(opts...)
 	pb.RegisterLaptopServiceServer(grpsServer, server)
 
 	l, err := net.Listen("tcp", ":0")
@@ -224,6 +859,43 @@ func startTestLaptopServer(t *testing.T,
 
 }
 
+func uploadTestImage(t *testing.T, client pb.LaptopServiceClient, laptopID string, imagePath string) string {
+	resp, err := sendTestImage(t, client, laptopID, imagePath)
+	require.NoError(t, err)
+	return resp.GetId()
+}
+
+// sendTestImage uploads the image in a single stream and
+// returns the error of the server if the upload is rejected.
+func sendTestImage(t *testing.T, client pb.LaptopServiceClient, laptopID string, imagePath string) (*pb.UploadImageResponse, error) {
+	data, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+
+	stream, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptopID,
+				ImageType: filepath.Ext(imagePath),
+			},
+		},
+	})
+	for err == nil && len(data) > 0 {
+		n := min(len(data), 1024)
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{
+				ChunkData: data[:n],
+			},
+		})
+		data = data[n:]
+	}
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	return stream.CloseAndRecv()
+}
+
 func newTestLaptopClient(t *testing.T, serverAddr string) pb.LaptopServiceClient {
 	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	require.NoError(t, err)
@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"main/certgen"
+	"main/models"
+	"main/pb"
+	"main/sample"
+	"main/service"
+	"main/storage"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientCreateLaptopWithMTLS(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	ca, err := certgen.NewCA("test CA", time.Hour)
+	require.NoError(t, err)
+	serverPair, err := ca.IssueServerCert([]string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	userStorage := storage.NewUserStorage()
+	admin, err := models.NewUser("ingestion", "secret", "admin")
+	require.NoError(t, err)
+	require.NoError(t, userStorage.Save(admin))
+	user, err := models.NewUser("reader", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStorage.Save(user))
+
+	serverAddr := startTestMTLSServer(t, ca, serverPair, userStorage)
+
+	testCases := []struct {
+		name         string
+		commonName   string
+		sans         []string
+		expectedCode codes.Code
+	}{
+		{name: "common name", commonName: "ingestion", expectedCode: codes.OK},
+		{name: "san", commonName: "job-42", sans: []string{"ingestion"}, expectedCode: codes.OK},
+		{name: "wrong role", commonName: "reader", expectedCode: codes.PermissionDenied},
+		{name: "unknown user", commonName: "stranger", expectedCode: codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			clientPair, err := ca.IssueClientCert(tc.commonName, tc.sans, time.Hour)
+			require.NoError(t, err)
+			client := newTestMTLSClient(t, serverAddr, ca, clientPair)
+
+			laptop := sample.NewLaptop()
+			res, err := client.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+			if tc.expectedCode == codes.OK {
+				require.NoError(t, err)
+				require.Equal(t, laptop.GetId(), res.GetId())
+			} else {
+				require.Error(t, err)
+				state, ok := status.FromError(err)
+				require.True(t, ok)
+				require.Equal(t, tc.expectedCode, state.Code())
+			}
+		})
+	}
+}
+
+func startTestMTLSServer(t *testing.T,
+	ca *certgen.CA,
+	serverPair *certgen.KeyPair,
+	userStorage service.UserStorager,
+) string {
+	serverCert, err := tls.X509KeyPair(serverPair.CertPEM, serverPair.KeyPEM)
+	require.NoError(t, err)
+	certPool := x509.NewCertPool()
+	require.True(t, certPool.AppendCertsFromPEM(ca.CertPEM))
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certPool,
+	})
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	certMapper := service.NewCertIdentityMapper(userStorage)
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, certMapper, map[string]string{
+		"/pc.LaptopService/CreateLaptop": service.PermissionLaptopCreate,
+	}, nil)
+	server := service.NewLaptopServer(storage.NewInMemoryLaptopStorage(), service.LaptopServerOptions{})
+	grpcServer := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, server)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(l)
+	t.Cleanup(grpcServer.Stop)
+
+	return l.Addr().String()
+}
+
+func newTestMTLSClient(t *testing.T, serverAddr string, ca *certgen.CA, clientPair *certgen.KeyPair) pb.LaptopServiceClient {
+	clientCert, err := tls.X509KeyPair(clientPair.CertPEM, clientPair.KeyPEM)
+	require.NoError(t, err)
+	certPool := x509.NewCertPool()
+	require.True(t, certPool.AppendCertsFromPEM(ca.CertPEM))
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      certPool,
+	})
+
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewLaptopServiceClient(conn)
+}
@@ -0,0 +1,151 @@
+package service_test
+
+import (
+	"context"
+	"main/blob"
+	"main/models"
+	"main/pb"
+	"main/sample"
+	"main/service"
+	"main/storage"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testImageSize = 14804
+
+func TestClientUploadImageQuota(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	imageStorage := storage.NewImageStorage(blob.NewMemoryStore())
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	userLaptop := sample.NewLaptop()
+	laptop1.CreatedBy = "vendor1"
+	laptop2.CreatedBy = "vendor1"
+	userLaptop.CreatedBy = "user1"
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop1))
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop2))
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, userLaptop))
+
+	quotaPolicy := service.NewQuotaPolicy(
+		service.UploadLimits{MaxImageSize: 1024},
+		map[string]service.UploadLimits{
+			"vendor": {MaxImageSize: 1 << 20, MaxImagesPerLaptop: 2, StorageQuota: 2*testImageSize + 1000},
+		},
+	)
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{ImageStorage: imageStorage, QuotaPolicy: quotaPolicy})
+	vendorAddr := serveTestLaptopServer(t, server, withTestPrincipal(&service.Principal{Username: "vendor1", Role: "vendor"})...)
+	userAddr := serveTestLaptopServer(t, server, withTestPrincipal(&service.Principal{Username: "user1", Role: "user"})...)
+	vendorClient := newTestLaptopClient(t, vendorAddr)
+	userClient := newTestLaptopClient(t, userAddr)
+
+	// default limits reject the image before it is received
+	_, err := sendTestImage(t, userClient, userLaptop.GetId(), "../tmp/laptop.jpg")
+	requireQuotaFailure(t, err, "image")
+	_, err = userClient.InitiateUpload(ctx, &pb.InitiateUploadRequest{
+		Info:     &pb.ImageInfo{LaptopId: userLaptop.GetId()},
+		ByteSize: testImageSize,
+	})
+	requireQuotaFailure(t, err, "image")
+
+	uploadTestImage(t, vendorClient, laptop1.GetId(), "../tmp/laptop.jpg")
+	uploadTestImage(t, vendorClient, laptop1.GetId(), "../tmp/gopher.webp")
+
+	noisePath := t.TempDir() + "/noise.png"
+	writeNoisePNG(t, noisePath, 32, 32)
+	_, err = sendTestImage(t, vendorClient, laptop1.GetId(), noisePath)
+	requireQuotaFailure(t, err, "laptop:"+laptop1.GetId())
+
+	uploadTestImage(t, vendorClient, laptop2.GetId(), "../tmp/laptop.jpg")
+	quota, err := vendorClient.GetQuota(ctx, &pb.GetQuotaRequest{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1<<20, quota.GetMaxImageSize())
+	require.EqualValues(t, 2, quota.GetMaxImagesPerLaptop())
+	require.EqualValues(t, 2*testImageSize+1000, quota.GetStorageQuota())
+	require.EqualValues(t, 3, quota.GetImageCount())
+	require.EqualValues(t, 2*testImageSize+442, quota.GetUsedBytes())
+
+	_, err = sendTestImage(t, vendorClient, laptop2.GetId(), noisePath)
+	requireQuotaFailure(t, err, "user:vendor1")
+
+	quota, err = userClient.GetQuota(ctx, &pb.GetQuotaRequest{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1024, quota.GetMaxImageSize())
+	require.Zero(t, quota.GetStorageQuota())
+	require.Zero(t, quota.GetImageCount())
+}
+
+func TestInitiateUploadLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	laptop := sample.NewLaptop()
+	laptop.CreatedBy = "vendor1"
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	quotaPolicy := service.NewQuotaPolicy(service.UploadLimits{MaxImageSize: 1000, StorageQuota: 1800}, nil)
+	// the upload folder is created with the first session
+	uploadStorage := storage.NewUploadStorage(filepath.Join(t.TempDir(), "uploads"), time.Hour, 2)
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{
+		UploadStorage: uploadStorage,
+		QuotaPolicy:   quotaPolicy,
+	})
+	vendorClient := newTestLaptopClient(t, serveTestLaptopServer(t, server,
+		withTestPrincipal(&service.Principal{Username: "vendor1", Role: "vendor"})...))
+	initiate := func(byteSize uint64) error {
+		_, err := vendorClient.InitiateUpload(ctx, &pb.InitiateUploadRequest{
+			Info:     &pb.ImageInfo{LaptopId: laptop.GetId()},
+			ByteSize: byteSize,
+		})
+		return err
+	}
+
+	// declared sizes of uploads in progress count against the quota
+	require.NoError(t, initiate(1000))
+	requireQuotaFailure(t, initiate(1000), "user:vendor1")
+	require.NoError(t, initiate(500))
+
+	// rejected uploads are not counted, the limit of open uploads is reached
+	err := initiate(1)
+	requireQuotaFailure(t, err, "user:vendor1")
+	require.Contains(t, status.Convert(err).Message(), "uploads in progress")
+}
+
+func requireQuotaFailure(t *testing.T, err error, subject string) {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, st.Code(), st.Message())
+	require.Len(t, st.Details(), 1)
+	failure, ok := st.Details()[0].(*errdetails.QuotaFailure)
+	require.True(t, ok)
+	require.Equal(t, subject, failure.GetViolations()[0].GetSubject())
+}
+
+// withTestPrincipal authenticates every request as the principal.
+func withTestPrincipal(principal *service.Principal) []grpc.ServerOption {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(service.ContextWithPrincipal(ctx, principal), req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &principalStream{ServerStream: ss, principal: principal})
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary), grpc.ChainStreamInterceptor(stream)}
+}
+
+type principalStream struct {
+	grpc.ServerStream
+	principal *service.Principal
+}
+
+func (s *principalStream) Context() context.Context {
+	return service.ContextWithPrincipal(s.ServerStream.Context(), s.principal)
+}
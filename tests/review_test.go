@@ -0,0 +1,300 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"main/models"
+	"main/pb"
+	"main/sample"
+	"main/service"
+	"main/storage"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientReviews(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	server := service.NewReviewServer(storage.NewReviewStorage(), laptopStorage, ratingStorage, nil)
+	users := make([]pb.ReviewServiceClient, 3)
+	for i := range users {
+		principal := &service.Principal{Username: "user" + string(rune('1'+i)), Role: "user"}
+		users[i] = newTestReviewClient(t, serveTestReviewServer(t, server, withTestPrincipal(principal)...))
+	}
+	admin := newTestReviewClient(t, serveTestReviewServer(t, server,
+		withTestPrincipal(&service.Principal{Username: "admin1", Role: "admin"})...))
+	anonymous := newTestReviewClient(t, serveTestReviewServer(t, server))
+
+	_, err := anonymous.SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 8, Title: "Good", Text: "Fast enough",
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = users[0].SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 8, Title: "", Text: "Fast enough",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = users[0].SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 11, Title: "Good", Text: "Fast enough",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = users[0].SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: "unknown", Score: 8, Title: "Good", Text: "Fast enough",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	reviewIDs := make([]string, len(users))
+	for i, user := range users {
+		res, err := user.SubmitReview(ctx, &pb.SubmitReviewRequest{
+			LaptopId: laptop.GetId(), Score: 8, Title: "Good", Text: "Fast enough",
+		})
+		require.NoError(t, err)
+		require.Equal(t, pb.Review_PENDING, res.GetReview().GetStatus())
+		reviewIDs[i] = res.GetReview().GetId()
+		// keep creation times distinct, so the order of reviews is known
+		time.Sleep(time.Millisecond)
+	}
+	// scores of pending reviews do not count
+	scores, err := ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Empty(t, scores)
+
+	list, err := users[0].ListReviews(ctx, &pb.ListReviewsRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, list.GetReviews())
+
+	queue, err := admin.ListModerationQueue(ctx, &pb.ListModerationQueueRequest{})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs, pbReviewIDs(queue.GetReviews()))
+
+	for i, action := range []pb.ModerateReviewRequest_Action{
+		pb.ModerateReviewRequest_APPROVE,
+		pb.ModerateReviewRequest_APPROVE,
+		pb.ModerateReviewRequest_FLAG,
+	} {
+		res, err := admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewIDs[i], Action: action})
+		require.NoError(t, err)
+		require.Equal(t, "admin1", res.GetReview().GetModeratedBy())
+	}
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: "unknown", Action: pb.ModerateReviewRequest_REJECT})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewIDs[0]})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	scores, err = ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*storage.Score{{RatedBy: "user1", Value: 8}, {RatedBy: "user2", Value: 8}}, scores)
+
+	// flagged review stays in the queue until it is approved or rejected
+	queue, err = admin.ListModerationQueue(ctx, &pb.ListModerationQueueRequest{})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[2:], pbReviewIDs(queue.GetReviews()))
+
+	// approved reviews are listed newest first, page by page
+	list, err = users[2].ListReviews(ctx, &pb.ListReviewsRequest{LaptopId: laptop.GetId(), PageSize: 1})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[1:2], pbReviewIDs(list.GetReviews()))
+	require.NotEmpty(t, list.GetNextPageToken())
+	list, err = users[2].ListReviews(ctx, &pb.ListReviewsRequest{
+		LaptopId:  laptop.GetId(),
+		PageSize:  1,
+		PageToken: list.GetNextPageToken(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[:1], pbReviewIDs(list.GetReviews()))
+	require.Empty(t, list.GetNextPageToken())
+
+	_, err = users[2].ListReviews(ctx, &pb.ListReviewsRequest{LaptopId: laptop.GetId(), PageToken: "not a token"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// edited review keeps its id and goes back to moderation
+	res, err := users[0].SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 4, Title: "Not so good", Text: "Battery died",
+	})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[0], res.GetReview().GetId())
+	require.Equal(t, pb.Review_PENDING, res.GetReview().GetStatus())
+	list, err = users[2].ListReviews(ctx, &pb.ListReviewsRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[1:2], pbReviewIDs(list.GetReviews()))
+	scores, err = ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user2", Value: 8}}, scores)
+
+	// rejected review loses its score, approved edit gets the new one
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewIDs[1], Action: pb.ModerateReviewRequest_REJECT})
+	require.NoError(t, err)
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewIDs[0], Action: pb.ModerateReviewRequest_APPROVE})
+	require.NoError(t, err)
+	scores, err = ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 4}}, scores)
+}
+
+// failingRatingStorage cannot change scores.
+type failingRatingStorage struct {
+	service.RatingStorager
+}
+
+func (failingRatingStorage) RateReview(tenantID string, laptopId string, author string, score float64) (*storage.Rating, error) {
+	return nil, errors.New("rating storage is unavailable")
+}
+
+func TestReviewKeepsDirectRating(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	principal := &service.Principal{Username: "user1", Role: "user"}
+	server := service.NewReviewServer(storage.NewReviewStorage(), laptopStorage, ratingStorage, nil)
+	user := newTestReviewClient(t, serveTestReviewServer(t, server, withTestPrincipal(principal)...))
+	admin := newTestReviewClient(t, serveTestReviewServer(t, server,
+		withTestPrincipal(&service.Principal{Username: "admin1", Role: "admin"})...))
+	laptopServer := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{RatingStorage: ratingStorage})
+	laptopClient := newTestLaptopClient(t, serveTestLaptopServer(t, laptopServer, withTestPrincipal(principal)...))
+
+	_, err := ratingStorage.Rate(models.DefaultTenantID, laptop.GetId(), "user1", 6)
+	require.NoError(t, err)
+	res, err := user.SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 9, Title: "Good", Text: "Fast enough",
+	})
+	require.NoError(t, err)
+	reviewID := res.GetReview().GetId()
+
+	// approved review replaces the direct score in the rating
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewID, Action: pb.ModerateReviewRequest_APPROVE})
+	require.NoError(t, err)
+	scores, err := ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 9}}, scores)
+
+	// rejected review gives the direct score back
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewID, Action: pb.ModerateReviewRequest_REJECT})
+	require.NoError(t, err)
+	scores, err = ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 6}}, scores)
+
+	// deleting the direct rating keeps the score of the approved review
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: reviewID, Action: pb.ModerateReviewRequest_APPROVE})
+	require.NoError(t, err)
+	deleted, err := laptopClient.DeleteMyRating(ctx, &pb.DeleteMyRatingRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), deleted.GetRatedCount())
+	require.Equal(t, 9.0, deleted.GetAverageScore())
+	_, err = laptopClient.DeleteMyRating(ctx, &pb.DeleteMyRatingRequest{LaptopId: laptop.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	scores, err = ratingStorage.Scores(models.DefaultTenantID, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 9}}, scores)
+}
+
+func TestModerateReviewRatingFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop))
+
+	server := service.NewReviewServer(storage.NewReviewStorage(), laptopStorage, failingRatingStorage{storage.NewRatingStorage()}, nil)
+	user := newTestReviewClient(t, serveTestReviewServer(t, server,
+		withTestPrincipal(&service.Principal{Username: "user1", Role: "user"})...))
+	admin := newTestReviewClient(t, serveTestReviewServer(t, server,
+		withTestPrincipal(&service.Principal{Username: "admin1", Role: "admin"})...))
+
+	res, err := user.SubmitReview(ctx, &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(), Score: 8, Title: "Good", Text: "Fast enough",
+	})
+	require.NoError(t, err)
+	_, err = admin.ModerateReview(ctx, &pb.ModerateReviewRequest{ReviewId: res.GetReview().GetId(), Action: pb.ModerateReviewRequest_APPROVE})
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	// review is not approved without its score
+	queue, err := admin.ListModerationQueue(ctx, &pb.ListModerationQueueRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{res.GetReview().GetId()}, pbReviewIDs(queue.GetReviews()))
+	require.Equal(t, pb.Review_PENDING, queue.GetReviews()[0].GetStatus())
+}
+
+func pbReviewIDs(reviews []*pb.Review) []string {
+	ids := make([]string, 0, len(reviews))
+	for _, review := range reviews {
+		ids = append(ids, review.GetId())
+	}
+	return ids
+}
+
+func serveTestReviewServer(t *testing.T, server *service.ReviewServer, opts ...grpc.ServerOption) string {
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterReviewServiceServer(grpcServer, server)
+	t.Cleanup(grpcServer.Stop)
+
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(l)
+
+	return l.Addr().String()
+}
+
+func newTestReviewClient(t *testing.T, serverAddr string) pb.ReviewServiceClient {
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	return pb.NewReviewServiceClient(conn)
+}
+
+func TestDeleteLaptopDeletesReviewsAndRatings(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	laptopStorage := storage.NewInMemoryLaptopStorage()
+	ratingStorage := storage.NewRatingStorage()
+	reviewStorage := storage.NewReviewStorage()
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop1))
+	require.NoError(t, laptopStorage.Save(models.DefaultTenantID, laptop2))
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		_, err := ratingStorage.Rate(models.DefaultTenantID, laptop.GetId(), "user1", 6)
+		require.NoError(t, err)
+		review, err := models.NewReview(models.DefaultTenantID, laptop.GetId(), "user2", 8, "Good", "Fast enough")
+		require.NoError(t, err)
+		_, err = reviewStorage.Save(review)
+		require.NoError(t, err)
+	}
+
+	server := service.NewLaptopServer(laptopStorage, service.LaptopServerOptions{
+		RatingStorage: ratingStorage,
+		ReviewStorage: reviewStorage,
+	})
+	client := newTestLaptopClient(t, serveTestLaptopServer(t, server))
+	_, err := client.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{LaptopId: laptop1.GetId()})
+	require.NoError(t, err)
+
+	events, err := ratingStorage.Events(models.DefaultTenantID, laptop1.GetId(), time.Now())
+	require.NoError(t, err)
+	require.Empty(t, events)
+	reviews, err := reviewStorage.List(models.DefaultTenantID, laptop1.GetId(), models.ReviewPending)
+	require.NoError(t, err)
+	require.Empty(t, reviews)
+
+	// other laptops keep their ratings and reviews
+	scores, err := ratingStorage.Scores(models.DefaultTenantID, laptop2.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []*storage.Score{{RatedBy: "user1", Value: 6}}, scores)
+	reviews, err = reviewStorage.List(models.DefaultTenantID, laptop2.GetId(), models.ReviewPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+}